@@ -0,0 +1,62 @@
+package grammar
+
+import (
+  "errors"
+  "strings"
+
+  "gopkg.in/olivere/elastic.v5"
+
+  "github.com/elireisman/go_es_query_parser/utils"
+)
+
+
+// values bound to "${name}" placeholders, i.e. Params{"user": "bob", "since": t}. bound values are
+// type-checked against the placeholder's position, and are never parsed as DSL
+type Params map[string]interface{}
+
+// translates a DSL query into an ES query, with its placeholders bound to params. uses the CLI's
+// defaults: AND as the default operator, and "_all" as the default field. malformed queries,
+// missing or mistyped params and failing registered functions are returned as errors, never fatal
+func Translate(query string, params Params) (out elastic.Query, err error) {
+  expanded, err := utils.ExpandMacros(query)
+  if err != nil {
+    return nil, err
+  }
+
+  dsl := &DSL2ES{
+    Queries:    &utils.QueryStack{Collect: true},
+    Values:     &utils.ValueStack{MultiMatchType: "best_fields", Params: params, Collect: true},
+    Buffer:     expanded,
+  }
+  // errors parsing can't carry on from are raised as a utils.Abort, reported with any collected so far
+  defer func() {
+    if r := recover(); r != nil {
+      abort, ok := r.(utils.Abort)
+      if !ok {
+        panic(r)
+      }
+      out, err = nil, joinErrors(append(dsl.Values.Errors, abort.Err))
+    }
+  }()
+
+  dsl.Init()
+  dsl.Queries.Init(false)
+  dsl.Values.Init("_all")
+  if err := dsl.Parse(); err != nil {
+    return nil, err
+  }
+  dsl.Execute()
+  if errs := dsl.Values.Errors; len(errs) > 0 {
+    return nil, joinErrors(errs)
+  }
+
+  return dsl.Queries.Output, nil
+}
+
+func joinErrors(errs []error) error {
+  msgs := []string{}
+  for _, err := range errs {
+    msgs = append(msgs, err.Error())
+  }
+  return errors.New(strings.Join(msgs, "; "))
+}
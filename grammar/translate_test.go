@@ -0,0 +1,108 @@
+package grammar
+
+import (
+  "encoding/json"
+  "strings"
+  "testing"
+)
+
+
+// translates query, rendering the result as JSON
+func translateJSON(t *testing.T, query string, params Params) string {
+  q, err := Translate(query, params)
+  if err != nil {
+    t.Fatalf("Translate(%q) failed: %s", query, err)
+  }
+  src, err := q.Source()
+  if err != nil {
+    t.Fatalf("Translate(%q) failed to render: %s", query, err)
+  }
+  out, _ := json.Marshal(src)
+  return string(out)
+}
+
+func TestTranslateGroupsBeforeOr(t *testing.T) {
+  cases := []struct {
+    query         string
+    expected      string
+  }{
+    {
+      "FILTER(a:x) OR c:z",
+      `{"bool":{"should":[{"bool":{"filter":{"bool":{"must":{"term":{"a":"x"}}}}}},{"match":{"c":{"query":"z"}}}]}}`,
+    },
+    {
+      "2 OF (a:x, b:y) OR c:z",
+      `{"bool":{"should":[{"bool":{"minimum_should_match":"2","should":[{"match":{"b":{"query":"y"}}},{"match":{"a":{"query":"x"}}}]}},{"match":{"c":{"query":"z"}}}]}}`,
+    },
+    {
+      "BEST(a:x, b:y) OR c:z",
+      `{"bool":{"should":[{"dis_max":{"queries":[{"match":{"b":{"query":"y"}}},{"match":{"a":{"query":"x"}}}]}},{"match":{"c":{"query":"z"}}}]}}`,
+    },
+    {
+      "(a:x AND b:y) OR c:z",
+      `{"bool":{"should":[{"bool":{"must":[{"match":{"b":{"query":"y"}}},{"match":{"a":{"query":"x"}}}]}},{"match":{"c":{"query":"z"}}}]}}`,
+    },
+    {
+      "c:z OR FILTER(a:x)",
+      `{"bool":{"should":[{"bool":{"filter":{"bool":{"must":{"term":{"a":"x"}}}}}},{"match":{"c":{"query":"z"}}}]}}`,
+    },
+    {
+      "FILTER(a:x) AND c:z",
+      `{"bool":{"filter":{"bool":{"must":{"term":{"a":"x"}}}},"must":{"match":{"c":{"query":"z"}}}}}`,
+    },
+  }
+
+  for _, c := range cases {
+    if actual := translateJSON(t, c.query, nil); actual != c.expected {
+      t.Errorf("Translate(%q) = %s, expected %s", c.query, actual, c.expected)
+    }
+  }
+}
+
+func TestTranslateParams(t *testing.T) {
+  actual := translateJSON(t, "user:${user} AND 1 <= count < ${hi} AND id:IN ${ids}", Params{"user": "bob", "hi": 5, "ids": []int{1, 2}})
+  expected := `{"bool":{"must":[{"terms":{"id":[1,2]}},{"range":{"count":{"from":1,"include_lower":true,"include_upper":false,"to":5}}},{"match":{"user":{"query":"bob"}}}]}}`
+  if actual != expected {
+    t.Errorf("Translate() = %s, expected %s", actual, expected)
+  }
+}
+
+func TestTranslateErrors(t *testing.T) {
+  cases := []struct {
+    query         string
+    params        Params
+    expected      string
+  }{
+    // bind and type errors are all reported together
+    {"a:${missing}", nil, "no value is bound to placeholder ${missing}"},
+    {"a:${list}", Params{"list": []string{"x"}}, "placeholder ${list} is bound to a list, which can only follow IN"},
+    {"ts:>=${since}", Params{"since": "yesterday"}, "placeholder ${since} is a range bound, so strings must be RFC3339 datetimes"},
+    {"a:IN ${ids}", Params{"ids": "x"}, "placeholder ${ids} follows IN, so it takes a list of values"},
+    {"a:${x} AND b:${y}", nil, "no value is bound to placeholder ${x}; no value is bound to placeholder ${y}"},
+    {"a:nope(x)", nil, "unknown function \"nope\""},
+    // grammar-level errors abort the translation, but don't exit
+    {"a AND b OR c", nil, "mixing operators in the same query clause is illegal"},
+    {"a:${x} AND b OR c", nil, "no value is bound to placeholder ${x}; mixing operators"},
+    {"BEST~2(a, b)", nil, "tie breaker must be a number between 0 and 1"},
+    {"a RANK BY boost(x)", nil, "unknown rank function \"boost\""},
+    {"_source:x", nil, "meta-field \"_source\" can't be searched"},
+    {"(title,_id):x", nil, "meta-field \"_id\" only supports exact values"},
+    {`a:x{boost=high}`, nil, "must be a number"},
+    {"a AND", nil, "parse error"},
+    {"2 OF (a)", nil, "2 OF ( ... ) has only 1 clause(s), so it could never match"},
+    {"3 OF (a, (b AND c))", nil, "3 OF ( ... ) has only 2 clause(s)"},
+    {"150% OF (a, b)", nil, "can't require more than 100% of its clauses"},
+    {"LET a = (x) IN b", nil, "binding \"a\" at column 5 is never referenced"},
+  }
+
+  for _, c := range cases {
+    _, err := Translate(c.query, c.params)
+    if err == nil {
+      t.Errorf("Translate(%q) succeeded, expected error %q", c.query, c.expected)
+      continue
+    }
+    if !strings.Contains(err.Error(), c.expected) {
+      t.Errorf("Translate(%q) error = %q, expected %q", c.query, err, c.expected)
+    }
+  }
+}
@@ -5,6 +5,7 @@ import (
   "encoding/json"
   "fmt"
   "flag"
+  "io/ioutil"
   "log"
   "os"
 
@@ -19,10 +20,19 @@ const NoInput = "ERR_NO_INPUT_PROVIDED"
 func main() {
   // reqister and parse CLI args
   query := flag.String("query", NoInput, "the query (written in the DSL) you wish to submit")
+  file := flag.String("file", "", "read the query from a .esq file, whose header options apply unless set as flags")
   isFilter := flag.Bool("filter", false, "structure the output as a filtered match_all instead of standard query")
   verbose := flag.Bool("verbose", false, "log/explain verbosely during parsing")
-  defField := flag.String("default", "_all", "select a default field for non-KV values to applied against in the final query")
+  defField := flag.String("default", "_all", "default field(s) for non-KV values to be applied against in the final query, comma-separated with optional boosts: title^3,body")
   defOper := flag.Bool("default-or", false, "override default query clause operator AND, use OR instead")
+  minMatch := flag.String("min-should-match", "", "minimum_should_match for OR groups without an explicit N OF count, i.e. 2 or 75%")
+  impliedFilter := flag.Bool("implied-filter", false, "place exists and range clauses of AND groups in filter context, as they don't affect scoring")
+  strictNot := flag.Bool("strict-not", false, "negated field clauses also require the field to exist, so docs lacking it don't match")
+  noRaw := flag.Bool("no-raw", false, "reject RAW{...} clauses embedding JSON queries as-is")
+  noQS := flag.Bool("no-query-string", false, "reject QS\"...\" clauses passing Lucene syntax through to query_string")
+  multiType := flag.String("multi-type", "best_fields", "multi_match type for values targeting several fields: best_fields, most_fields, cross_fields, phrase or phrase_prefix")
+  params := flag.String("params", "", "JSON object of values bound to ${name} placeholders, i.e. {\"user\": \"bob\", \"ids\": [1, 2]}")
+  template := flag.Bool("template", false, "output a mustache search template with ${name} placeholders as {{name}} slots, plus a params skeleton")
   halp := flag.Bool("help", false, "print DSL and usage details and exit")
   flag.Parse()
 
@@ -30,18 +40,55 @@ func main() {
     log.Println(usage())
     os.Exit(1)
   }
+  if *file != "" {
+    if *query != NoInput {
+      log.Println("-query and -file arguments can't be used together, aborting")
+      os.Exit(1)
+    }
+    *query = loadQueryFile(*file, *verbose)
+  }
   if *query == NoInput {
     log.Println("-query argument specifying query string is required, aborting")
     os.Exit(1)
   }
+  switch *multiType {
+  case "best_fields", "most_fields", "cross_fields", "phrase", "phrase_prefix":
+  default:
+    log.Printf("-multi-type argument %q is not a valid multi_match type, aborting", *multiType)
+    os.Exit(1)
+  }
+
+  var bound map[string]interface{}
+  if *params != "" {
+    if err := json.Unmarshal([]byte(*params), &bound); err != nil {
+      log.Printf("-params argument must be a JSON object, err=%s, aborting", err)
+      os.Exit(1)
+    }
+  }
+
+  // expand LET bindings before parsing, the parser only ever sees the expanded query
+  expanded, err := utils.ExpandMacros(*query)
+  if err != nil {
+    log.Fatalf("[ERROR] %s", err)
+  }
+  if *verbose && expanded != *query {
+    log.Printf("[INFO] expanded query: %s", expanded)
+  }
 
   // init DSL state object and parse the input
   dsl := &grammar.DSL2ES{
-    Queries:    &utils.QueryStack{},
-    Values:     &utils.ValueStack{},
+    Queries:    &utils.QueryStack{MinShouldMatch: *minMatch, StrictNegation: *strictNot, ImpliedFilter: *impliedFilter},
+    Values:     &utils.ValueStack{
+      Filtered:           *isFilter,
+      MultiMatchType:     *multiType,
+      DisableRaw:         *noRaw,
+      DisableQueryString: *noQS,
+      Params:             bound,
+      Template:           *template,
+    },
     Verbose:    *verbose,
     IsFilter:   *isFilter,
-    Buffer:     *query,
+    Buffer:     expanded,
   }
 
   dsl.Init()
@@ -64,7 +111,6 @@ func main() {
 
   // render final query/filter output
   var rendered interface{}
-  var err error
   if dsl.IsFilter {
     rendered, err = elastic.NewBoolQuery().Filter(dsl.Queries.Output).Source()
   } else {
@@ -79,10 +125,49 @@ func main() {
   if err != nil {
     log.Fatalf("failed to marshal rendered query as JSON, err=%s", err)
   }
-  fmt.Println(`{"query":` + string(j) + `}`)
+  output := `{"query":` + string(j) + `}`
+  if *template {
+    if output, err = utils.SearchTemplate(output, dsl.Values.Slots(), bound); err != nil {
+      log.Fatalf("failed to render search template, err=%s", err)
+    }
+  }
+  fmt.Println(output)
+}
+
+// reads a .esq query file, applying its header options to the flags not set on the command line
+func loadQueryFile(path string, verbose bool) string {
+  src, err := ioutil.ReadFile(path)
+  if err != nil {
+    log.Fatalf("[ERROR] failed to read query file %q, err=%s", path, err)
+  }
+  qf, err := utils.ParseQueryFile(string(src))
+  if err != nil {
+    log.Fatalf("[ERROR] query file %q: %s", path, err)
+  }
+
+  explicit := map[string]bool{}
+  flag.Visit(func(f *flag.Flag) {
+    explicit[f.Name] = true
+  })
+  for name, value := range qf.Options {
+    switch {
+    case name == "query" || name == "file" || name == "help" || flag.Lookup(name) == nil:
+      log.Fatalf("[ERROR] query file %q: unknown header option %q", path, name)
+    case explicit[name]:
+      continue
+    }
+    if err := flag.Set(name, value); err != nil {
+      log.Fatalf("[ERROR] query file %q: invalid value %q for header option %q, err=%s", path, value, name, err)
+    }
+  }
+
+  if verbose {
+    log.Printf("[INFO] query file %q: name=%q owner=%q description=%q", path, qf.Name, qf.Owner, qf.Description)
+  }
+  return qf.Query
 }
 
 func usage() string {
-  return fmt.Sprintf("Usage: %s --query 'QUERY_STRING' [--filter] [--verbose] [--help]", os.Args[0])
+  return fmt.Sprintf("Usage: %s --query 'QUERY_STRING' | --file QUERY.esq [--filter] [--verbose] [--help]", os.Args[0])
   // TODO: detail the DSL grammar etc. here also, or with verbose + help opts together only?
 }
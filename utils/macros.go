@@ -0,0 +1,279 @@
+package utils
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+)
+
+
+// a LET binding: its parenthesised sub-query, where that starts in the original input,
+// and where the binding's name is
+type macro struct {
+  body          string
+  offset        int
+  at            int
+}
+
+// expands "LET name = ( ... ), other = ( ... ) IN query" bindings, replacing each "@name" reference
+// or bare "name" value in the query (and in later bindings) with the bound sub-query, parens
+// included. this happens before parsing, so the expansion is parsed like any other input. queries
+// without a LET block are left as they are. "@name" followed by a ":" or comparison operator is a key and not a
+// reference, as are "@names" inside field lists like "(title,@timestamp):x", IN lists and LIKE
+// sources. undefined, cyclic and never referenced bindings are reported with their position in
+// the original input
+func ExpandMacros(query string) (string, error) {
+  defs, offset, err := parseLets(query)
+  if err != nil || len(defs) == 0 {
+    return query, err
+  }
+
+  used := map[string]bool{}
+  expanded, err := expandRefs(query, query[offset:], offset, defs, []string{}, used)
+  if err != nil {
+    return "", err
+  }
+
+  // "LET x = ( ... ) IN x" is an easy mistake, and would silently drop the binding
+  unused := []string{}
+  for name := range defs {
+    if !used[name] {
+      unused = append(unused, name)
+    }
+  }
+  if len(unused) > 0 {
+    sort.Slice(unused, func(i, j int) bool { return defs[unused[i]].at < defs[unused[j]].at })
+    return "", fmt.Errorf("binding %q at %s is never referenced, use @%s in the query", unused[0], position(query, defs[unused[0]].at), unused[0])
+  }
+
+  return expanded, nil
+}
+
+// parses any leading LET bindings, returning them and the offset of the query body that follows
+func parseLets(query string) (map[string]macro, int, error) {
+  defs := map[string]macro{}
+  ndx := skipSpace(query, 0)
+  if !strings.HasPrefix(query[ndx:], "LET") || ndx + 3 >= len(query) || !isSpace(query[ndx + 3]) {
+    return defs, 0, nil
+  }
+
+  for ndx += 3; ; {
+    ndx = skipSpace(query, ndx)
+    start := ndx
+    for ndx < len(query) && isNameChar(query[ndx], ndx > start) {
+      ndx++
+    }
+    name := query[start:ndx]
+    if name == "" {
+      return nil, 0, fmt.Errorf("expected a binding name at %s", position(query, start))
+    }
+    if _, found := defs[name]; found {
+      return nil, 0, fmt.Errorf("binding %q at %s is already defined", name, position(query, start))
+    }
+
+    if ndx = skipSpace(query, ndx); ndx >= len(query) || query[ndx] != '=' {
+      return nil, 0, fmt.Errorf("expected '=' after binding %q at %s", name, position(query, ndx))
+    }
+    if ndx = skipSpace(query, ndx + 1); ndx >= len(query) || query[ndx] != '(' {
+      return nil, 0, fmt.Errorf("binding %q at %s must be a parenthesised sub-query", name, position(query, ndx))
+    }
+    end, err := closingParen(query, ndx)
+    if err != nil {
+      return nil, 0, err
+    }
+    defs[name] = macro{query[ndx:end + 1], ndx, start}
+
+    ndx = skipSpace(query, end + 1)
+    if ndx < len(query) && query[ndx] == ',' {
+      ndx++
+      continue
+    }
+    if !strings.HasPrefix(query[ndx:], "IN") || (ndx + 2 < len(query) && !isSpace(query[ndx + 2])) {
+      return nil, 0, fmt.Errorf("expected ',' or IN after binding %q at %s", name, position(query, ndx))
+    }
+    return defs, ndx + 2, nil
+  }
+}
+
+// copies text, replacing "@name" references and bare binding names with their bindings. offset is
+// where text starts in the original query, stack holds the bindings being expanded, to catch cycles,
+// and used collects the bindings referenced
+func expandRefs(query, text string, offset int, defs map[string]macro, stack []string, used map[string]bool) (string, error) {
+  var out strings.Builder
+  quoted := false
+  // open parens, true for field-scoped groups like "title:( ... )" where bare names are values
+  groups := []bool{}
+  scoped := func() bool {
+    for _, group := range groups {
+      if group {
+        return true
+      }
+    }
+    return false
+  }
+
+  expand := func(name string, at int) error {
+    for _, expanding := range stack {
+      if expanding == name {
+        return fmt.Errorf("cyclic reference @%s at %s: @%s -> @%s", name, position(query, offset + at), strings.Join(stack, " -> @"), name)
+      }
+    }
+    used[name] = true
+    expanded, err := expandRefs(query, defs[name].body, defs[name].offset, defs, append(stack, name), used)
+    out.WriteString(expanded)
+    return err
+  }
+
+  for ndx := 0; ndx < len(text); ndx++ {
+    c := text[ndx]
+    switch {
+    case c == '\\' && ndx + 1 < len(text):
+      out.WriteString(text[ndx:ndx + 2])
+      ndx++
+      continue
+    case c == '"':
+      quoted = !quoted
+    case quoted:
+    case c == '(' && (listKeyword(text[:ndx]) || inFieldList(text, ndx + 1)):
+      // "IN (@alice, @bob)", "LIKE(@timestamp)" and "(title,@timestamp):x" hold values and
+      // keys, not references
+      end, err := closingParen(text, ndx)
+      if err != nil {
+        // unbalanced, left for the parser to report
+        groups = append(groups, false)
+        break
+      }
+      out.WriteString(text[ndx:end + 1])
+      ndx = end
+      continue
+    case c == '(':
+      groups = append(groups, strings.HasSuffix(strings.TrimRight(text[:ndx], " \t\r\n"), ":"))
+    case c == ')' && len(groups) > 0:
+      groups = groups[:len(groups) - 1]
+    case c == '@' && (ndx == 0 || strings.IndexByte(" \t\r\n(,!+-", text[ndx - 1]) >= 0) && ndx + 1 < len(text) && isNameChar(text[ndx + 1], false):
+      end := ndx + 1
+      for end < len(text) && (isNameChar(text[end], true) || strings.IndexByte(".@*-", text[end]) >= 0) {
+        end++
+      }
+      // keys like "@timestamp:x" or "@timestamp >= x" aren't references
+      if next := skipSpace(text, end); next < len(text) && strings.IndexByte(":<>=!~%", text[next]) >= 0 {
+        out.WriteString(text[ndx:end])
+        ndx = end - 1
+        continue
+      }
+
+      name := text[ndx + 1:end]
+      if _, found := defs[name]; !found {
+        return "", fmt.Errorf("undefined reference @%s at %s", name, position(query, offset + ndx))
+      }
+      if err := expand(name, ndx); err != nil {
+        return "", err
+      }
+      ndx = end - 1
+      continue
+    case isNameChar(c, false) && wordStart(text, ndx):
+      end := ndx
+      for end < len(text) && isNameChar(text[end], true) {
+        end++
+      }
+      // a bare binding name standing as a whole value, like "IN prod AND x", is a reference too.
+      // not as a key ("prod:x"), or inside a field-scoped group, where "title:(prod)" is a value
+      name, next := text[ndx:end], skipSpace(text, end)
+      _, found := defs[name]
+      if found && !scoped() && (end == len(text) || strings.IndexByte(" \t\r\n),", text[end]) >= 0) && (next == len(text) || strings.IndexByte(":<>=!~%", text[next]) < 0) {
+        if err := expand(name, ndx); err != nil {
+          return "", err
+        }
+      } else {
+        out.WriteString(name)
+      }
+      ndx = end - 1
+      continue
+    }
+    out.WriteByte(c)
+  }
+
+  return out.String(), nil
+}
+
+// reports whether ndx starts a word: it follows a separator, or "!", "+" and "-" prefixes that do
+func wordStart(text string, ndx int) bool {
+  for ndx > 0 && strings.IndexByte("!+-", text[ndx - 1]) >= 0 {
+    ndx--
+  }
+  return ndx == 0 || strings.IndexByte(" \t\r\n(,", text[ndx - 1]) >= 0
+}
+
+// reports whether text ends in an IN or LIKE keyword, opening a value or source list
+func listKeyword(text string) bool {
+  text = strings.TrimRight(text, " \t\r\n")
+  for _, keyword := range []string{"IN", "LIKE"} {
+    if start := len(text) - len(keyword); strings.HasSuffix(text, keyword) && (start == 0 || !isNameChar(text[start - 1], true)) {
+      return true
+    }
+  }
+
+  return false
+}
+
+// reports whether ndx is inside a field list like "(title,@timestamp):x": the next paren
+// is a ")" immediately followed by ":", with no quotes before it
+func inFieldList(text string, ndx int) bool {
+  for ; ndx < len(text); ndx++ {
+    switch text[ndx] {
+    case '(', '"':
+      return false
+    case ')':
+      return ndx + 1 < len(text) && text[ndx + 1] == ':'
+    }
+  }
+
+  return false
+}
+
+// finds the paren closing the one at open, skipping quoted text
+func closingParen(query string, open int) (int, error) {
+  depth, quoted := 0, false
+  for ndx := open; ndx < len(query); ndx++ {
+    switch c := query[ndx]; {
+    case c == '\\':
+      ndx++
+    case c == '"':
+      quoted = !quoted
+    case quoted:
+    case c == '(':
+      depth++
+    case c == ')':
+      if depth--; depth == 0 {
+        return ndx, nil
+      }
+    }
+  }
+
+  return 0, fmt.Errorf("unbalanced parens in binding starting at %s", position(query, open))
+}
+
+// 1-based column of offset in the query, with the line too for multi-line input
+func position(query string, offset int) string {
+  line := strings.Count(query[:offset], "\n") + 1
+  col := offset - strings.LastIndex(query[:offset], "\n")
+  if strings.Contains(query, "\n") {
+    return fmt.Sprintf("line %d, column %d", line, col)
+  }
+  return fmt.Sprintf("column %d", col)
+}
+
+func skipSpace(s string, ndx int) int {
+  for ndx < len(s) && isSpace(s[ndx]) {
+    ndx++
+  }
+  return ndx
+}
+
+func isSpace(c byte) bool {
+  return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isNameChar(c byte, digits bool) bool {
+  return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (digits && c >= '0' && c <= '9')
+}
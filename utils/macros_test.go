@@ -0,0 +1,81 @@
+package utils
+
+import (
+  "strings"
+  "testing"
+)
+
+
+func TestExpandMacros(t *testing.T) {
+  cases := []struct {
+    query         string
+    expected      string
+  }{
+    {"a AND b", "a AND b"},
+    {"LET a = (x:1) IN @a AND b", " (x:1) AND b"},
+    {"LET a = (x:1), b = (@a OR y) IN @b", " ((x:1) OR y)"},
+    {"LET a = (x) IN !@a", " !(x)"},
+    {"LET a = (x) IN (@a)", " ((x))"},
+    {"LET a = (x) IN @a AND @timestamp:>=2017-10-31", " (x) AND @timestamp:>=2017-10-31"},
+    {"LET a = (x) IN @a AND @timestamp >= 10", " (x) AND @timestamp >= 10"},
+    {"LET a = (x) IN @a AND (title,@timestamp):y", " (x) AND (title,@timestamp):y"},
+    {`LET a = (x) IN @a AND "@a"`, ` (x) AND "@a"`},
+    {"LET a = (x) IN @a AND team:IN @(users/team/eng#members)", " (x) AND team:IN @(users/team/eng#members)"},
+    {"LET a = (\"(\" OR y) IN @a", " (\"(\" OR y)"},
+    {"LETTER:x", "LETTER:x"},
+    {"x@a", "x@a"},
+    {"a AND @b", "a AND @b"},
+    {"handle:IN (@alice, @bob)", "handle:IN (@alice, @bob)"},
+    {"LET a = (x) IN @a AND handle:IN (@alice, @bob)", " (x) AND handle:IN (@alice, @bob)"},
+    {"LET a = (x) IN @a AND handle:IN(@a)", " (x) AND handle:IN(@a)"},
+    {`LET a = (x) IN @a AND LIKE(@timestamp, _id:1){"y"}`, ` (x) AND LIKE(@timestamp, _id:1){"y"}`},
+    {"LET a = (x) IN BEGIN (@a)", " BEGIN ((x))"},
+    {"LET prod = (env:prod AND !host:canary*) IN prod AND status:>=500", " (env:prod AND !host:canary*) AND status:>=500"},
+    {"LET a = (x), b = (a OR y) IN !b", " !((x) OR y)"},
+    {"LET a = (x) IN (a) OR -a", " ((x)) OR -(x)"},
+    {"LET a = (x) IN a AND a:1 AND a >= 2 AND title:(a) AND \"a\" AND ab AND b.a AND a~1", " (x) AND a:1 AND a >= 2 AND title:(a) AND \"a\" AND ab AND b.a AND a~1"},
+  }
+
+  for _, c := range cases {
+    actual, err := ExpandMacros(c.query)
+    if err != nil {
+      t.Errorf("ExpandMacros(%q) failed: %s", c.query, err)
+      continue
+    }
+    if actual != c.expected {
+      t.Errorf("ExpandMacros(%q) = %q, expected %q", c.query, actual, c.expected)
+    }
+  }
+}
+
+func TestExpandMacrosErrors(t *testing.T) {
+  cases := []struct {
+    query         string
+    expected      string
+  }{
+    {"LET a = (x) IN @a AND @b", "undefined reference @b at column 23"},
+    {"LET a = (x) IN @a AND\n  @b", "undefined reference @b at line 2, column 3"},
+    {"LET a = (@b), b = (@a) IN @a", "cyclic reference @a at column 20: @a -> @b -> @a"},
+    {"LET a = (x), a = (y) IN @a", "binding \"a\" at column 14 is already defined"},
+    {"LET a (x) IN @a", "expected '=' after binding \"a\" at column 7"},
+    {"LET a = x IN @a", "binding \"a\" at column 9 must be a parenthesised sub-query"},
+    {"LET a = (x IN @a", "unbalanced parens in binding starting at column 9"},
+    {"LET a = (x) @a", "expected ',' or IN after binding \"a\" at column 13"},
+    {"LET = (x) IN @a", "expected a binding name at column 5"},
+    {"LET prod = (env:prod) IN prods AND status:>=500", "binding \"prod\" at column 5 is never referenced"},
+    {"LET prod = (env:prod) IN title:(prod)", "binding \"prod\" at column 5 is never referenced"},
+    {"LET a = (b), b = (a) IN a", "cyclic reference @a at column 19: @a -> @b -> @a"},
+    {"LET a = (x), b = (y) IN @a", "binding \"b\" at column 14 is never referenced"},
+  }
+
+  for _, c := range cases {
+    _, err := ExpandMacros(c.query)
+    if err == nil {
+      t.Errorf("ExpandMacros(%q) succeeded, expected error %q", c.query, c.expected)
+      continue
+    }
+    if !strings.Contains(err.Error(), c.expected) {
+      t.Errorf("ExpandMacros(%q) error = %q, expected %q", c.query, err, c.expected)
+    }
+  }
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+
+  "gopkg.in/olivere/elastic.v5"
+)
+
+
+// meta-fields with their own query types, usable as keys with exact values and IN lists
+var metaFields = map[string]bool{
+  "_id": true, "_index": true, "_type": true, "_routing": true,
+}
+
+// meta-fields that can't be searched, or not usefully (_all is searchable like any other field)
+var unsupportedMetaFields = map[string]bool{
+  "_uid": true, "_source": true, "_size": true, "_field_names": true, "_parent": true,
+  "_meta": true, "_version": true, "_seq_no": true, "_score": true, "_ignored": true,
+}
+
+// rejects unsupported meta-fields as keys
+func (vs *ValueStack) checkMetaField(field string) {
+  if unsupportedMetaFields[field] {
+    vs.abort(fmt.Errorf("meta-field %q can't be searched, supported meta-fields are _id, _index, _type and _routing", field))
+  }
+}
+
+// renders exact values or IN lists against a meta-field: "ids" for _id, "type" for _type
+// and "term"/"terms" for _index and _routing
+func (vs *ValueStack) metaQuery(field string, value interface{}) elastic.Query {
+  if lookup, ok := value.(*elastic.TermsLookup); ok {
+    if field == "_type" {
+      vs.abort(fmt.Errorf("meta-field _type doesn't support terms lookups"))
+    }
+    return elastic.NewTermsQuery(field).TermsLookup(lookup)
+  }
+
+  values := []interface{}{value}
+  list, isList := value.([]interface{})
+  if isList {
+    values = list
+  }
+  strs := []string{}
+  for _, v := range values {
+    strs = append(strs, metaString(v))
+  }
+
+  switch field {
+  case "_id":
+    return elastic.NewIdsQuery().Ids(strs...)
+
+  case "_type":
+    if len(strs) == 1 {
+      return elastic.NewTypeQuery(strs[0])
+    }
+    bq := elastic.NewBoolQuery()
+    for _, typ := range strs {
+      bq.Should(elastic.NewTypeQuery(typ))
+    }
+    return bq
+
+  default:
+    // IN lists stay "terms" clauses even with a single value, so template list slots render as lists
+    if len(strs) == 1 && !isList {
+      return elastic.NewTermQuery(field, strs[0])
+    }
+    terms := []interface{}{}
+    for _, s := range strs {
+      terms = append(terms, s)
+    }
+    return elastic.NewTermsQuery(field, terms...)
+  }
+}
+
+// meta-field values are always strings, so numeric-looking ids like "_id:123" are formatted back
+func metaString(value interface{}) string {
+  if f, ok := value.(float64); ok {
+    return strconv.FormatFloat(f, 'f', -1, 64)
+  }
+  return fmt.Sprint(value)
+}
+
+// meta-fields can't be mixed into multi-field targets, or used with ranges, wildcards and the like
+func (vs *ValueStack) rejectMetaFields(tmp *Value, kind string) {
+  fields := tmp.Fields
+  if len(fields) == 0 {
+    fields = []string{tmp.Field}
+  }
+  for _, field := range fields {
+    if metaFields[strings.SplitN(field, "^", 2)[0]] {
+      vs.abort(fmt.Errorf("meta-field %q only supports exact values and IN lists as the sole target, not %s", field, kind))
+    }
+  }
+}
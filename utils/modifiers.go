@@ -0,0 +1,202 @@
+package utils
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+
+  "gopkg.in/olivere/elastic.v5"
+)
+
+
+// one option from a value's trailing modifiers: "@and" shorthand or a "{key=value, ...}" block
+type Modifier struct {
+  Key           string
+  Value         string
+}
+
+// short spellings accepted in modifier blocks
+var modifierAliases = map[string]string{
+  "msm": "minimum_should_match",
+}
+
+// options only a match (or multi_match) query can honour. quoted phrases given one are re-rendered as a match
+var matchOnlyModifiers = map[string]bool{
+  "operator": true, "minimum_should_match": true, "fuzziness": true,
+}
+
+// parses "@and" / "@or" or "{msm=75%, analyzer=english}" into modifiers, in the order given
+func ParseModifiers(mods string) ([]Modifier, error) {
+  if strings.HasPrefix(mods, "@") {
+    return []Modifier{{"operator", mods[1:]}}, nil
+  }
+
+  out := []Modifier{}
+  for _, opt := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(mods, "{"), "}"), ",") {
+    kv := strings.SplitN(opt, "=", 2)
+    if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+      return nil, fmt.Errorf("malformed modifier %q in %s, expected key=value", strings.TrimSpace(opt), mods)
+    }
+
+    key := strings.ToLower(strings.TrimSpace(kv[0]))
+    if alias, ok := modifierAliases[key]; ok {
+      key = alias
+    }
+    out = append(out, Modifier{key, strings.TrimSpace(kv[1])})
+  }
+
+  return out, nil
+}
+
+func modifyMatch(q *elastic.MatchQuery, m Modifier) error {
+  switch m.Key {
+  case "operator":
+    op, err := operatorValue(m)
+    q.Operator(op)
+    return err
+  case "minimum_should_match":
+    q.MinimumShouldMatch(m.Value)
+  case "analyzer":
+    q.Analyzer(m.Value)
+  case "fuzziness":
+    fuzz, err := fuzzinessValue(m)
+    q.Fuzziness(fuzz)
+    return err
+  case "boost":
+    boost, err := floatValue(m)
+    q.Boost(boost)
+    return err
+  default:
+    return unknownModifier("match", m, "operator, minimum_should_match (msm), analyzer, fuzziness or boost")
+  }
+
+  return nil
+}
+
+func modifyPhrase(q *elastic.MatchPhraseQuery, m Modifier) error {
+  switch m.Key {
+  case "analyzer":
+    q.Analyzer(m.Value)
+  case "slop":
+    slop, err := intValue(m)
+    q.Slop(slop)
+    return err
+  case "boost":
+    boost, err := floatValue(m)
+    q.Boost(boost)
+    return err
+  default:
+    return unknownModifier("phrase", m, "operator, minimum_should_match (msm), fuzziness, analyzer, slop or boost")
+  }
+
+  return nil
+}
+
+func modifyMultiMatch(q *elastic.MultiMatchQuery, m Modifier) error {
+  switch m.Key {
+  case "operator":
+    op, err := operatorValue(m)
+    q.Operator(op)
+    return err
+  case "minimum_should_match":
+    q.MinimumShouldMatch(m.Value)
+  case "analyzer":
+    q.Analyzer(m.Value)
+  case "fuzziness":
+    fuzz, err := fuzzinessValue(m)
+    q.Fuzziness(fuzz)
+    return err
+  case "slop":
+    slop, err := intValue(m)
+    q.Slop(slop)
+    return err
+  case "boost":
+    boost, err := floatValue(m)
+    q.Boost(boost)
+    return err
+  case "type":
+    switch m.Value {
+    case "best_fields", "most_fields", "cross_fields", "phrase", "phrase_prefix":
+      q.Type(m.Value)
+    default:
+      return fmt.Errorf("modifier type=%s is not a valid multi_match type, expected best_fields, most_fields, cross_fields, phrase or phrase_prefix", m.Value)
+    }
+  default:
+    return unknownModifier("multi-field", m, "operator, minimum_should_match (msm), analyzer, fuzziness, slop, boost or type")
+  }
+
+  return nil
+}
+
+func modifyMoreLikeThis(q *elastic.MoreLikeThisQuery, m Modifier) error {
+  switch m.Key {
+  case "min_term_freq", "max_query_terms", "min_doc_freq", "max_doc_freq", "min_word_len", "max_word_len":
+    n, err := intValue(m)
+    switch m.Key {
+    case "min_term_freq":
+      q.MinTermFreq(n)
+    case "max_query_terms":
+      q.MaxQueryTerms(n)
+    case "min_doc_freq":
+      q.MinDocFreq(n)
+    case "max_doc_freq":
+      q.MaxDocFreq(n)
+    case "min_word_len":
+      q.MinWordLen(n)
+    case "max_word_len":
+      q.MaxWordLen(n)
+    }
+    return err
+  case "minimum_should_match":
+    q.MinimumShouldMatch(m.Value)
+  case "analyzer":
+    q.Analyzer(m.Value)
+  case "boost_terms":
+    boost, err := floatValue(m)
+    q.BoostTerms(boost)
+    return err
+  case "boost":
+    boost, err := floatValue(m)
+    q.Boost(boost)
+    return err
+  default:
+    return unknownModifier("LIKE", m, "min_term_freq, max_query_terms, min_doc_freq, max_doc_freq, min_word_len, max_word_len, minimum_should_match (msm), analyzer, boost_terms or boost")
+  }
+
+  return nil
+}
+
+func unknownModifier(kind string, m Modifier, valid string) error {
+  return fmt.Errorf("modifier %q is not supported on %s values, expected %s", m.Key, kind, valid)
+}
+
+func operatorValue(m Modifier) (string, error) {
+  op := strings.ToLower(m.Value)
+  if op != "and" && op != "or" {
+    return op, fmt.Errorf("modifier operator=%s must be \"and\" or \"or\"", m.Value)
+  }
+  return op, nil
+}
+
+func fuzzinessValue(m Modifier) (string, error) {
+  if m.Value == "AUTO" || m.Value == "0" || m.Value == "1" || m.Value == "2" {
+    return m.Value, nil
+  }
+  return m.Value, fmt.Errorf("modifier fuzziness=%s must be AUTO, 0, 1 or 2", m.Value)
+}
+
+func intValue(m Modifier) (int, error) {
+  n, err := strconv.Atoi(m.Value)
+  if err != nil || n < 0 {
+    return n, fmt.Errorf("modifier %s=%s must be a non-negative integer", m.Key, m.Value)
+  }
+  return n, nil
+}
+
+func floatValue(m Modifier) (float64, error) {
+  f, err := strconv.ParseFloat(m.Value, 64)
+  if err != nil {
+    return f, fmt.Errorf("modifier %s=%s must be a number", m.Key, m.Value)
+  }
+  return f, nil
+}
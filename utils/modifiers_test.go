@@ -0,0 +1,47 @@
+package utils
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+)
+
+
+func TestParseModifiers(t *testing.T) {
+  cases := []struct {
+    mods          string
+    expected      []Modifier
+  }{
+    {"@and", []Modifier{{"operator", "and"}}},
+    {"@or", []Modifier{{"operator", "or"}}},
+    {"{msm=75%}", []Modifier{{"minimum_should_match", "75%"}}},
+    {"{ Analyzer = english , fuzziness=AUTO}", []Modifier{{"analyzer", "english"}, {"fuzziness", "AUTO"}}},
+    {"{slop=2, boost=1.5}", []Modifier{{"slop", "2"}, {"boost", "1.5"}}},
+  }
+
+  for _, c := range cases {
+    actual, err := ParseModifiers(c.mods)
+    if err != nil {
+      t.Errorf("ParseModifiers(%q) failed: %s", c.mods, err)
+      continue
+    }
+    if !reflect.DeepEqual(actual, c.expected) {
+      t.Errorf("ParseModifiers(%q) = %v, expected %v", c.mods, actual, c.expected)
+    }
+  }
+}
+
+func TestParseModifiersErrors(t *testing.T) {
+  cases := []string{"{}", "{slop}", "{=2}", "{slop=}", "{slop=2,}"}
+
+  for _, mods := range cases {
+    _, err := ParseModifiers(mods)
+    if err == nil {
+      t.Errorf("ParseModifiers(%q) succeeded, expected an error", mods)
+      continue
+    }
+    if !strings.Contains(err.Error(), "expected key=value") {
+      t.Errorf("ParseModifiers(%q) error = %q, expected a malformed modifier error", mods, err)
+    }
+  }
+}
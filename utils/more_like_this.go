@@ -0,0 +1,81 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "strings"
+
+  "gopkg.in/olivere/elastic.v5"
+)
+
+
+// parses the body of a LIKE clause into a more_like_this query. the parens hold comma-separated
+// fields and "_id:..." docs to find similar docs to, and the optional braces hold sample texts:
+// `(title,body){"some sample text"}` or `(_id:abc123, _id:tickets/ticket/def456)`. docs given
+// as a bare id are looked up in the index being searched
+func ParseMoreLikeThis(expr string) (*elastic.MoreLikeThisQuery, error) {
+  rparen := strings.Index(expr, ")")
+  if !strings.HasPrefix(expr, "(") || rparen < 0 {
+    return nil, fmt.Errorf("malformed LIKE clause LIKE%s, expected LIKE(fields or _id:docs){\"texts\"}", expr)
+  }
+
+  mlt := elastic.NewMoreLikeThisQuery()
+  fields, docs := []string{}, []*elastic.MoreLikeThisQueryItem{}
+  for _, src := range strings.Split(expr[1:rparen], ",") {
+    src = strings.TrimSpace(src)
+    switch {
+    case src == "":
+      return nil, fmt.Errorf("LIKE%s: empty field or doc in the source list", expr)
+    case strings.HasPrefix(src, "_id:"):
+      doc, err := likeDoc(strings.TrimPrefix(src, "_id:"))
+      if err != nil {
+        return nil, fmt.Errorf("LIKE%s: %s", expr, err)
+      }
+      docs = append(docs, doc)
+    default:
+      fields = append(fields, Unescape(strings.Trim(src, `"`)))
+    }
+  }
+
+  // sample texts are JSON strings, so they decode as a JSON array once the braces are swapped out
+  texts := []string{}
+  if body := strings.TrimSpace(expr[rparen + 1:]); body != "" {
+    if err := json.Unmarshal([]byte("[" + body[1:len(body) - 1] + "]"), &texts); err != nil {
+      return nil, fmt.Errorf("LIKE%s: sample texts must be quoted strings, err=%s", expr, err)
+    }
+  }
+
+  if len(docs) == 0 && len(texts) == 0 {
+    return nil, fmt.Errorf("LIKE%s needs at least one _id:doc or {\"sample text\"} to find similar docs to", expr)
+  }
+  if len(fields) > 0 {
+    mlt.Field(fields...)
+  }
+  if len(texts) > 0 {
+    mlt.LikeText(texts...)
+  }
+  if len(docs) > 0 {
+    mlt.LikeItems(docs...)
+  }
+
+  return mlt, nil
+}
+
+// a doc reference: "abc123", or "index/type/abc123" for docs in other indices
+func likeDoc(ref string) (*elastic.MoreLikeThisQueryItem, error) {
+  parts := strings.Split(ref, "/")
+  for _, part := range parts {
+    if part == "" {
+      return nil, fmt.Errorf("malformed doc reference _id:%s, expected id or index/type/id", ref)
+    }
+  }
+
+  switch len(parts) {
+  case 1:
+    return elastic.NewMoreLikeThisQueryItem().Id(parts[0]), nil
+  case 3:
+    return elastic.NewMoreLikeThisQueryItem().Index(parts[0]).Type(parts[1]).Id(parts[2]), nil
+  default:
+    return nil, fmt.Errorf("malformed doc reference _id:%s, expected id or index/type/id", ref)
+  }
+}
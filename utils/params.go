@@ -0,0 +1,78 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "time"
+)
+
+
+// checks a value bound to a "${name}" placeholder in value position: strings, booleans,
+// numbers and datetimes are accepted, and numbers of any Go type become float64s
+func paramScalar(name string, value interface{}) (interface{}, error) {
+  switch v := value.(type) {
+  case string, bool, float64, time.Time:
+    return v, nil
+  case json.Number:
+    return v.Float64()
+  }
+
+  if num, ok := paramNumber(value); ok {
+    return num, nil
+  }
+  return nil, fmt.Errorf("placeholder ${%s} takes a string, number, boolean or time.Time value, got %T", name, value)
+}
+
+// checks a value bound to a placeholder in range position: numbers and datetimes, or RFC3339 strings
+func paramBound(name string, value interface{}) (interface{}, error) {
+  if s, ok := value.(string); ok {
+    t, err := time.Parse(time.RFC3339, s)
+    if err != nil {
+      return nil, fmt.Errorf("placeholder ${%s} is a range bound, so strings must be RFC3339 datetimes, got %q", name, s)
+    }
+    return t, nil
+  }
+
+  v, err := paramScalar(name, value)
+  if _, isBool := v.(bool); err != nil || isBool {
+    return nil, fmt.Errorf("placeholder ${%s} is a range bound, it takes a number or datetime, got %T", name, value)
+  }
+  return v, nil
+}
+
+// checks a value bound to a placeholder in list position ("IN ${name}"): any slice of scalars
+func paramList(name string, value interface{}) ([]interface{}, error) {
+  rv := reflect.ValueOf(value)
+  if value == nil || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+    return nil, fmt.Errorf("placeholder ${%s} follows IN, so it takes a list of values, got %T", name, value)
+  }
+  if rv.Len() == 0 {
+    return nil, fmt.Errorf("placeholder ${%s} is bound to an empty list", name)
+  }
+
+  out := []interface{}{}
+  for ndx := 0; ndx < rv.Len(); ndx++ {
+    v, err := paramScalar(fmt.Sprintf("%s[%d]", name, ndx), rv.Index(ndx).Interface())
+    if err != nil {
+      return nil, err
+    }
+    out = append(out, v)
+  }
+
+  return out, nil
+}
+
+func paramNumber(value interface{}) (float64, bool) {
+  rv := reflect.ValueOf(value)
+  switch rv.Kind() {
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return float64(rv.Int()), true
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return float64(rv.Uint()), true
+  case reflect.Float32, reflect.Float64:
+    return rv.Float(), true
+  default:
+    return 0, false
+  }
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+
+// parses a proximity chain like `"breach" BEFORE/5 "notify"` or `a NEAR/3 b` into span_near JSON
+// for the given field, as the elastic.v5 client has no span query builders. chains nest from the
+// left: "a NEAR/3 b BEFORE/2 c" is "(a NEAR/3 b) BEFORE/2 c". quoted operands must match as an
+// exact phrase. span terms aren't analyzed, so operands must match the indexed tokens
+func ParseProximity(field, expr string) (string, error) {
+  tokens, err := proximityTokens(expr)
+  if err != nil {
+    return "", err
+  }
+  if len(tokens) < 3 || len(tokens) % 2 == 0 {
+    return "", fmt.Errorf("malformed proximity clause %q, expected operands separated by NEAR/n or BEFORE/n", expr)
+  }
+
+  span, err := spanOperand(field, tokens[0])
+  if err != nil {
+    return "", err
+  }
+  for ndx := 1; ndx < len(tokens); ndx += 2 {
+    op := strings.SplitN(tokens[ndx], "/", 2)
+    if len(op) != 2 || (op[0] != "NEAR" && op[0] != "BEFORE") {
+      return "", fmt.Errorf("proximity clause %q: expected NEAR/n or BEFORE/n, got %q", expr, tokens[ndx])
+    }
+    slop, err := strconv.Atoi(op[1])
+    if err != nil || slop < 0 {
+      return "", fmt.Errorf("proximity clause %q: %s distance must be a non-negative integer, got %q", expr, op[0], op[1])
+    }
+
+    next, err := spanOperand(field, tokens[ndx + 1])
+    if err != nil {
+      return "", err
+    }
+    span = spanNear([]interface{}{span, next}, slop, op[0] == "BEFORE")
+  }
+
+  out, err := json.Marshal(span)
+  return string(out), err
+}
+
+// splits a proximity chain on whitespace, keeping quoted operands (quotes included) whole
+func proximityTokens(expr string) ([]string, error) {
+  tokens := []string{}
+  for expr = strings.TrimSpace(expr); expr != ""; expr = strings.TrimSpace(expr) {
+    end := strings.IndexAny(expr, " \t\r\n")
+    if expr[0] == '"' {
+      if end = strings.Index(expr[1:], `"`) + 2; end < 2 {
+        return nil, fmt.Errorf("unterminated quoted operand in proximity clause %q", expr)
+      }
+    } else if end < 0 {
+      end = len(expr)
+    }
+    tokens = append(tokens, expr[:end])
+    expr = expr[end:]
+  }
+
+  return tokens, nil
+}
+
+// a single word becomes a span_term, a quoted phrase an ordered span_near with no slop
+func spanOperand(field, operand string) (interface{}, error) {
+  words := strings.Fields(strings.Trim(operand, `"`))
+  if len(words) == 0 {
+    return nil, fmt.Errorf("empty operand %s in proximity clause on field %q", operand, field)
+  }
+
+  terms := []interface{}{}
+  for _, word := range words {
+    terms = append(terms, map[string]interface{}{"span_term": map[string]interface{}{field: word}})
+  }
+  if len(terms) == 1 {
+    return terms[0], nil
+  }
+  return spanNear(terms, 0, true), nil
+}
+
+func spanNear(clauses []interface{}, slop int, inOrder bool) interface{} {
+  return map[string]interface{}{
+    "span_near": map[string]interface{}{"clauses": clauses, "slop": slop, "in_order": inOrder},
+  }
+}
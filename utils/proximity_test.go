@@ -0,0 +1,69 @@
+package utils
+
+import (
+  "strings"
+  "testing"
+)
+
+
+func TestParseProximity(t *testing.T) {
+  cases := []struct {
+    expr          string
+    expected      string
+  }{
+    {
+      "a NEAR/3 b",
+      `{"span_near":{"clauses":[{"span_term":{"body":"a"}},{"span_term":{"body":"b"}}],"in_order":false,"slop":3}}`,
+    },
+    {
+      "a BEFORE/0 b",
+      `{"span_near":{"clauses":[{"span_term":{"body":"a"}},{"span_term":{"body":"b"}}],"in_order":true,"slop":0}}`,
+    },
+    {
+      `"data breach" BEFORE/10 notify`,
+      `{"span_near":{"clauses":[{"span_near":{"clauses":[{"span_term":{"body":"data"}},{"span_term":{"body":"breach"}}],"in_order":true,"slop":0}},{"span_term":{"body":"notify"}}],"in_order":true,"slop":10}}`,
+    },
+    {
+      "a NEAR/1 b BEFORE/2 c",
+      `{"span_near":{"clauses":[{"span_near":{"clauses":[{"span_term":{"body":"a"}},{"span_term":{"body":"b"}}],"in_order":false,"slop":1}},{"span_term":{"body":"c"}}],"in_order":true,"slop":2}}`,
+    },
+  }
+
+  for _, c := range cases {
+    actual, err := ParseProximity("body", c.expr)
+    if err != nil {
+      t.Errorf("ParseProximity(%q) failed: %s", c.expr, err)
+      continue
+    }
+    if actual != c.expected {
+      t.Errorf("ParseProximity(%q) = %s, expected %s", c.expr, actual, c.expected)
+    }
+  }
+}
+
+func TestParseProximityErrors(t *testing.T) {
+  cases := []struct {
+    expr          string
+    expected      string
+  }{
+    {"a", "malformed proximity clause"},
+    {"a NEAR/3", "malformed proximity clause"},
+    {"a AROUND/3 b", "expected NEAR/n or BEFORE/n"},
+    {"a NEAR b", "expected NEAR/n or BEFORE/n"},
+    {"a NEAR/x b", "NEAR distance must be a non-negative integer"},
+    {"a BEFORE/-1 b", "BEFORE distance must be a non-negative integer"},
+    {`"a b NEAR/3 c`, "unterminated quoted operand"},
+    {`"" NEAR/3 c`, "empty operand"},
+  }
+
+  for _, c := range cases {
+    _, err := ParseProximity("body", c.expr)
+    if err == nil {
+      t.Errorf("ParseProximity(%q) succeeded, expected error %q", c.expr, c.expected)
+      continue
+    }
+    if !strings.Contains(err.Error(), c.expected) {
+      t.Errorf("ParseProximity(%q) error = %q, expected %q", c.expr, err, c.expected)
+    }
+  }
+}
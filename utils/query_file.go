@@ -0,0 +1,119 @@
+package utils
+
+import (
+  "fmt"
+  "strings"
+)
+
+
+// header keys describing a saved query, rather than setting an option
+var queryFileMetadata = map[string]bool{
+  "name": true, "description": true, "owner": true,
+}
+
+// a saved .esq query: the query, plus the metadata and options of its optional header
+type QueryFile struct {
+  Name          string
+  Description   string
+  Owner         string
+  // header options by CLI flag name, i.e. "default" or "filter", with their values as written
+  Options       map[string]string
+  Query         string
+}
+
+// parses a .esq file: a query laid out over any number of lines, with "#" comments running to the
+// end of the line (a "#" inside a quoted value or a word like "eng#members" isn't a comment), and
+// an optional header of "key: value" lines between "---" lines at the top:
+//
+//   ---
+//   name: failing checkouts
+//   default: title^3,body
+//   filter: true
+//   ---
+//   service:checkout AND status:>=500  # server errors only
+//
+// comments and the header are blanked rather than removed, so positions in the query match the file
+func ParseQueryFile(src string) (*QueryFile, error) {
+  qf := &QueryFile{Options: map[string]string{}}
+  lines := strings.Split(stripComments(src), "\n")
+
+  start := 0
+  for start < len(lines) && strings.TrimSpace(lines[start]) == "" {
+    start++
+  }
+  if start < len(lines) && strings.TrimSpace(lines[start]) == "---" {
+    end := start + 1
+    for ; end < len(lines) && strings.TrimSpace(lines[end]) != "---"; end++ {
+      if err := qf.setHeader(lines[end], end + 1); err != nil {
+        return nil, err
+      }
+    }
+    if end == len(lines) {
+      return nil, fmt.Errorf("header starting on line %d is missing its closing ---", start + 1)
+    }
+    for ndx := start; ndx <= end; ndx++ {
+      lines[ndx] = ""
+    }
+  }
+
+  qf.Query = strings.Join(lines, "\n")
+  return qf, nil
+}
+
+func (qf *QueryFile) setHeader(line string, lineNum int) error {
+  if strings.TrimSpace(line) == "" {
+    return nil
+  }
+
+  kv := strings.SplitN(line, ":", 2)
+  key := strings.ToLower(strings.TrimSpace(kv[0]))
+  if len(kv) != 2 || key == "" {
+    return fmt.Errorf("malformed header line %d %q, expected key: value", lineNum, strings.TrimSpace(line))
+  }
+  value := strings.TrimSpace(kv[1])
+  if len(value) > 1 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+    value = Unescape(value[1:len(value) - 1])
+  }
+
+  switch {
+  case key == "name" && qf.Name == "":
+    qf.Name = value
+  case key == "description" && qf.Description == "":
+    qf.Description = value
+  case key == "owner" && qf.Owner == "":
+    qf.Owner = value
+  case queryFileMetadata[key]:
+    return fmt.Errorf("duplicate header key %q on line %d", key, lineNum)
+  default:
+    if _, found := qf.Options[key]; found {
+      return fmt.Errorf("duplicate header key %q on line %d", key, lineNum)
+    }
+    qf.Options[key] = value
+  }
+
+  return nil
+}
+
+// blanks out "#" comments: those at the start of a line or after whitespace, outside quoted values
+func stripComments(src string) string {
+  out := []byte(src)
+  quoted := false
+  for ndx := 0; ndx < len(out); ndx++ {
+    switch c := out[ndx]; {
+    case c == '\\':
+      ndx++
+    case c == '"':
+      quoted = !quoted
+    case c == '\n':
+      // an unterminated quote can't swallow the comments on later lines
+      quoted = false
+    case c == '#' && !quoted && (ndx == 0 || isSpace(out[ndx - 1])):
+      for ; ndx < len(out) && out[ndx] != '\n'; ndx++ {
+        out[ndx] = ' '
+      }
+      ndx--
+    }
+  }
+
+  return string(out)
+}
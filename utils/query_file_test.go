@@ -0,0 +1,62 @@
+package utils
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+)
+
+
+func TestParseQueryFile(t *testing.T) {
+  cases := []struct {
+    src           string
+    expected      QueryFile
+  }{
+    {"a AND b", QueryFile{"", "", "", map[string]string{}, "a AND b"}},
+    {
+      "---\nname: failing checkouts\ndefault: title^3,body\nfilter: true\n---\nservice:checkout",
+      QueryFile{"failing checkouts", "", "", map[string]string{"default": "title^3,body", "filter": "true"}, "\n\n\n\n\nservice:checkout"},
+    },
+    {
+      "\n---\nDescription: \"errors: 5xx\"\nowner: payments\n---\nx",
+      QueryFile{"", "errors: 5xx", "payments", map[string]string{}, "\n\n\n\n\nx"},
+    },
+    {"a  # comment\n# whole line\nb", QueryFile{"", "", "", map[string]string{}, "a           \n            \nb"}},
+    {`team:IN @(users/team/eng#members) AND "see #42"`, QueryFile{"", "", "", map[string]string{}, `team:IN @(users/team/eng#members) AND "see #42"`}},
+    {"\"open\n# comment", QueryFile{"", "", "", map[string]string{}, "\"open\n         "}},
+  }
+
+  for _, c := range cases {
+    actual, err := ParseQueryFile(c.src)
+    if err != nil {
+      t.Errorf("ParseQueryFile(%q) failed: %s", c.src, err)
+      continue
+    }
+    if !reflect.DeepEqual(*actual, c.expected) {
+      t.Errorf("ParseQueryFile(%q) = %#v, expected %#v", c.src, *actual, c.expected)
+    }
+  }
+}
+
+func TestParseQueryFileErrors(t *testing.T) {
+  cases := []struct {
+    src           string
+    expected      string
+  }{
+    {"---\nname: x\nowner: y", "header starting on line 1 is missing its closing ---"},
+    {"---\nfilter\n---\nx", "malformed header line 2 \"filter\""},
+    {"---\nname: x\nname: y\n---\nx", "duplicate header key \"name\" on line 3"},
+    {"---\nfilter: true\nfilter: false\n---\nx", "duplicate header key \"filter\" on line 3"},
+  }
+
+  for _, c := range cases {
+    _, err := ParseQueryFile(c.src)
+    if err == nil {
+      t.Errorf("ParseQueryFile(%q) succeeded, expected error %q", c.src, c.expected)
+      continue
+    }
+    if !strings.Contains(err.Error(), c.expected) {
+      t.Errorf("ParseQueryFile(%q) error = %q, expected %q", c.src, err, c.expected)
+    }
+  }
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+  "fmt"
   "log"
+  "strconv"
+  "strings"
 
   "gopkg.in/olivere/elastic.v5"
 )
@@ -13,6 +16,7 @@ const (
   DefaultOr
   And
   Or
+  Best
 )
 
 func (o Oper) String() string {
@@ -21,45 +25,121 @@ func (o Oper) String() string {
   case DefaultOr:  return "DEFAULT_OR"
   case And:        return "AND"
   case Or:         return "OR"
+  case Best:       return "BEST"
   default:         return "UNSET"
   }
 }
 
 type Query struct {
   BoolQ         *elastic.BoolQuery
+  // only set for BEST( ... ) groups, which render to dis_max instead of bool
+  DisMaxQ       *elastic.DisMaxQuery
   Oper          Oper
   Negate        bool
+  // minimum_should_match for "N OF ( ... )" groups, a count or percentage like "75%"
+  MinMatch      string
+  // number of child clauses added, so empty input can be told apart
+  Clauses       int
+  // FILTER( ... ) groups nest into the parent's filter bucket rather than scoring
+  Filtered      bool
+  // "+field:( ... )" and "-field:( ... )" groups must or must not match, whatever the parent's operator
+  Occur         Occur
+  // the field(s) a "field:( ... )" group is scoped to
+  Scope         *Value
+  // closed child levels, waiting to be nested when this level is composed, once its operator is final
+  children      []*Query
 }
 
 func (q *Query) Must(eq elastic.Query) {
   q.BoolQ.Must(eq)
+  q.Clauses++
 }
 
 func (q *Query) MustNot(eq elastic.Query) {
   q.BoolQ.MustNot(eq)
+  q.Clauses++
 }
 
 func (q *Query) Should(eq elastic.Query) {
   q.BoolQ.Should(eq)
+  q.Clauses++
 }
 
-func (q *Query) SetOper(op Oper) {
+func (q *Query) Filter(eq elastic.Query) {
+  q.BoolQ.Filter(eq)
+  q.Clauses++
+}
+
+func (q *Query) Best(eq elastic.Query) {
+  q.DisMaxQ.Query(eq)
+  q.Clauses++
+}
+
+// the ES query this level renders to
+func (q *Query) Rendered() elastic.Query {
+  if q.Oper == Best {
+    return q.DisMaxQ
+  }
+  return q.BoolQ
+}
+
+func (q *Query) SetTieBreaker(tie string) error {
+  if q.Oper != Best {
+    return fmt.Errorf("tie breaker %s is only valid on BEST( ... ) groups", tie)
+  }
+
+  f, err := strconv.ParseFloat(tie, 64)
+  if err != nil || f < 0 || f > 1 {
+    return fmt.Errorf("BEST( ... ) tie breaker must be a number between 0 and 1, got %q", tie)
+  }
+  q.DisMaxQ.TieBreaker(f)
+  return nil
+}
+
+func (q *Query) SetOper(op Oper) error {
   if q.Oper == Unset || q.Oper == DefaultAnd || q.Oper == DefaultOr {
     q.Oper = op
   } else if q.Oper != op {
-    log.Fatalf("[ERROR] mixing operators in the same query clause is illegal (current:%s, attempted:%s)", q.Oper, op)
+    return fmt.Errorf("mixing operators in the same query clause is illegal (current:%s, attempted:%s)", q.Oper, op)
+  }
+  return nil
+}
+
+
+// sets minimum_should_match on the bool query, as a count ("2") or percentage ("75%")
+func (q *Query) SetMinMatch(msm string) error {
+  if strings.HasSuffix(msm, "%") {
+    q.BoolQ.MinimumShouldMatch(msm)
+    return nil
   }
+
+  n, err := strconv.Atoi(msm)
+  if err != nil {
+    return fmt.Errorf("minimum should match must be a count or percentage, got %q, err=%s", msm, err)
+  }
+  q.BoolQ.MinimumNumberShouldMatch(n)
+  return nil
 }
 
 
 type QueryStack struct {
-  Output        *elastic.BoolQuery
-  defaultOp     Oper
-  stack         []*Query
+  Output          elastic.Query
+  // minimum_should_match applied to every OR group without an explicit "N OF" count
+  MinShouldMatch  string
+  // negated field clauses also require the field to exist, so docs lacking it don't match
+  StrictNegation  bool
+  // exists and range clauses in AND groups go to the filter bucket, as they'd score the same on every hit
+  ImpliedFilter   bool
+  defaultOp       Oper
+  stack           []*Query
+  // RANK BY score functions, wrapping Output in a function_score query when present
+  functions       []elastic.ScoreFunction
+  // raise errors as an Abort panic for the caller to recover, rather than exiting
+  Collect         bool
 }
 
 func NewLevel(op Oper, negate bool) *Query {
-  return &Query{elastic.NewBoolQuery(), op, negate}
+  return &Query{elastic.NewBoolQuery(), nil, op, negate, "", 0, false, Optional, nil, nil}
 }
 
 func (qs *QueryStack) Init(defaultToOr bool) {
@@ -78,19 +158,79 @@ func (qs *QueryStack) Empty() bool {
 // obtain a pointer to the "current" query
 func (qs *QueryStack) Current() *Query {
   if qs.Empty() {
-    log.Fatal("[ERROR] can't manipulate current query group - the stack is empty")
+    qs.abort(fmt.Errorf("can't manipulate current query group - the stack is empty"))
   }
   return qs.stack[len(qs.stack) - 1]
 }
 
+// sets the current level's AND/OR operator, which can't be mixed within a level
+func (qs *QueryStack) SetOper(op Oper) {
+  if err := qs.Current().SetOper(op); err != nil {
+    qs.abort(err)
+  }
+}
+
+// sets the current BEST( ... ) level's tie breaker
+func (qs *QueryStack) SetTieBreaker(tie string) {
+  if err := qs.Current().SetTieBreaker(tie); err != nil {
+    qs.abort(err)
+  }
+}
+
+func (qs *QueryStack) abort(err error) {
+  abort(qs.Collect, err)
+}
+
+// registers a "RANK BY" score function, i.e. "decay(created_at, origin=now, scale=7d)"
+func (qs *QueryStack) RankBy(fn string) {
+  sf, err := ParseRankFunction(fn)
+  if err != nil {
+    qs.abort(err)
+  }
+  qs.functions = append(qs.functions, sf)
+}
+
 func (qs *QueryStack) Push(negate bool) {
   qs.stack = append(qs.stack, NewLevel(qs.defaultOp, negate))
 }
 
+// pushes a "field:( ... )" group, which can carry a +required or -prohibited prefix
+func (qs *QueryStack) PushFieldGroup(scope *Value) {
+  level := NewLevel(qs.defaultOp, scope.Negate)
+  level.Occur, level.Scope = scope.Occur, scope
+  qs.stack = append(qs.stack, level)
+}
+
+// pushes an "N OF ( ... )" group: an OR level where at least N (or N%) of the clauses must match
+func (qs *QueryStack) PushMinMatch(negate bool, count string) {
+  n, err := strconv.Atoi(strings.TrimSuffix(count, "%"))
+  if err != nil || n <= 0 {
+    qs.abort(fmt.Errorf("%q OF ( ... ) requires a positive count or percentage", count))
+  }
+  if strings.HasSuffix(count, "%") && n > 100 {
+    qs.abort(fmt.Errorf("%q OF ( ... ) can't require more than 100%% of its clauses", count))
+  }
+
+  qs.stack = append(qs.stack, &Query{elastic.NewBoolQuery(), nil, Or, negate, count, 0, false, Optional, nil, nil})
+}
+
+// pushes a "BEST( ... )" group: its clauses are scored as a dis_max rather than summed in a bool
+func (qs *QueryStack) PushBest(negate bool) {
+  qs.stack = append(qs.stack, &Query{elastic.NewBoolQuery(), elastic.NewDisMaxQuery(), Best, negate, "", 0, false, Optional, nil, nil})
+}
+
+// pushes a "FILTER( ... )" group: its clauses match without contributing to the score
+func (qs *QueryStack) PushFilter(negate bool) {
+  qs.stack = append(qs.stack, &Query{elastic.NewBoolQuery(), nil, qs.defaultOp, negate, "", 0, true, Optional, nil, nil})
+}
+
 func (qs *QueryStack) Finalize(values []*Value) {
   result := qs.Compose(values)
 
   if len(qs.stack) > 1 {
+    if qs.Collect {
+      qs.abort(fmt.Errorf("input was not fully parsed, %d groups remain open", len(qs.stack) - 1))
+    }
     log.Println("[ERROR] input was not fully parsed, additional AST nodes remain on stack:")
     for ndx, frame := range qs.stack {
       log.Printf("[ERROR] [Stack Frame %d] %#v", len(qs.stack) - ndx, *frame)
@@ -98,69 +238,165 @@ func (qs *QueryStack) Finalize(values []*Value) {
     log.Fatal("[ERROR] aborting")
   }
 
-  // expose top-level parent ES query from final stack frame, this is our final parse result
+  // expose top-level parent ES query from final stack frame, this is our final parse result.
+  // empty (or all-whitespace) input means everything
   qs.Output = result.BoolQ
+  if result.Clauses == 0 {
+    qs.Output = elastic.NewMatchAllQuery()
+  }
+  if len(qs.functions) > 0 {
+    fsq := elastic.NewFunctionScoreQuery().Query(qs.Output)
+    for _, sf := range qs.functions {
+      fsq.AddScoreFunc(sf)
+    }
+    qs.Output = fsq
+  }
 }
 
 // when ')' or end-of-input is encountered, we pop the whole group of individual queries from the stack
 // back to the last '(' or start-of-input, and we inject into the parent bool query at proper bucket/nesting
 func (qs *QueryStack) Compose(values []*Value) *Query {
+  // Lucene-style clauses: once a default-operator clause holds a +required or -prohibited
+  // value or group, its unprefixed values and groups become optional, as they would in a query_string
+  cur := qs.Current()
+  oper, lucene := cur.Oper, false
+  if oper == DefaultAnd || oper == DefaultOr {
+    for _, v := range values {
+      lucene = lucene || v.Occur != Optional
+    }
+    for _, child := range cur.children {
+      lucene = lucene || child.Occur != Optional
+    }
+  }
+  if lucene {
+    oper = Or
+  }
+
+  // children nest under the level's final operator, so "FILTER(a) OR b" puts the filter in a should
+  for _, child := range cur.children {
+    qs.nest(cur, oper, child)
+  }
+  cur.children = nil
+
   for _, v := range values {
-    switch qs.Current().Oper {
+    if v.Occur != Optional && oper == Best {
+      qs.abort(fmt.Errorf("+/- prefixes can't be used inside BEST( ... ) groups, found one on field %q", v.Field))
+    }
+
+    // a -prohibited value is negated too, so "-NOT x" cancels out. in strict mode, negated
+    // field clauses become a positive "field exists but doesn't match" clause
+    q, negate := v.Q, v.Negate != (v.Occur == Prohibited)
+    if negate && qs.StrictNegation {
+      if guarded := strictNot(v.Q, v); guarded != nil {
+        q, negate = guarded, false
+      }
+    }
+
+    if v.Occur != Optional {
+      if negate {
+        qs.Current().MustNot(q)
+      } else {
+        qs.Current().Must(q)
+      }
+      continue
+    }
+
+    switch oper {
     // AND clause maps to Must, NOT AND to MustNot in parent query
     case And, DefaultAnd:
-      if v.Negate {
-        qs.Current().MustNot(v.Q)
+      if negate {
+        qs.Current().MustNot(q)
+      } else if qs.ImpliedFilter && scoreless(q) {
+        qs.Current().Filter(q)
       } else {
-        qs.Current().Must(v.Q)
+        qs.Current().Must(q)
       }
 
     // OR clause maps to Should, NOT OR clause we fake w/MustNot wrapped in the parent Should
     case Or, DefaultOr:
-      if v.Negate {
-        qs.Current().Should(elastic.NewBoolQuery().MustNot(v.Q))
+      if negate {
+        qs.Current().Should(elastic.NewBoolQuery().MustNot(q))
+      } else {
+        qs.Current().Should(q)
+      }
+
+    // BEST clause maps to a dis_max query, NOT BEST clause is faked like NOT OR
+    case Best:
+      if negate {
+        qs.Current().Best(elastic.NewBoolQuery().MustNot(q))
       } else {
-        qs.Current().Should(v.Q)
+        qs.Current().Best(q)
       }
 
     default:
-      log.Fatalf("[ERROR] invalid query clause operator in traversal results: %s", qs.Current().Oper)
+      qs.abort(fmt.Errorf("invalid query clause operator in traversal results: %s", qs.Current().Oper))
+    }
+  }
+
+  if cur := qs.Current(); cur.Oper == Or || cur.Oper == DefaultOr {
+    msm := qs.MinShouldMatch
+    if cur.MinMatch != "" {
+      // a count above the group's clauses could never match
+      if n, err := strconv.Atoi(cur.MinMatch); err == nil && n > cur.Clauses {
+        qs.abort(fmt.Errorf("%s OF ( ... ) has only %d clause(s), so it could never match", cur.MinMatch, cur.Clauses))
+      }
+      msm = cur.MinMatch
+    }
+    if msm != "" {
+      if err := cur.SetMinMatch(msm); err != nil {
+        qs.abort(err)
+      }
     }
   }
 
   return qs.Pop()
 }
 
+// the strict form of a negated field clause: "exists" in must alongside the clause in must_not.
+// returns nil for values with no explicit field, and for exists values where it'd be a contradiction
+func strictNot(q elastic.Query, v *Value) elastic.Query {
+  if _, ok := q.(*elastic.ExistsQuery); ok || !v.Keyed {
+    return nil
+  }
+
+  var exists elastic.Query
+  if len(v.Fields) == 0 {
+    exists = elastic.NewExistsQuery(v.Field)
+  } else {
+    any := elastic.NewBoolQuery()
+    for _, field := range v.Fields {
+      any.Should(elastic.NewExistsQuery(strings.SplitN(field, "^", 2)[0]))
+    }
+    exists = any
+  }
+
+  return elastic.NewBoolQuery().Must(exists).MustNot(q)
+}
+
+// exists and range clauses give every hit the same score, so they're filters in all but name
+func scoreless(q elastic.Query) bool {
+  switch q.(type) {
+  case *elastic.ExistsQuery, *elastic.RangeQuery:
+    return true
+  default:
+    return false
+  }
+}
+
 func (qs *QueryStack) Pop() *Query {
   // pop current nested query level from stack
   if qs.Empty() {
-    log.Fatal("[ERROR] can't pop subquery from empty stack, aborting")
+    qs.abort(fmt.Errorf("can't pop subquery from empty stack"))
   }
   last := len(qs.stack) - 1
   out := qs.stack[last]
   qs.stack = qs.stack[:last]
 
-  // if this is a child (nested) subquery, nest it properly in the parent level
   if len(qs.stack) > 0 {
-    switch qs.Current().Oper {
-    case And, DefaultAnd:
-      if out.Negate {
-        // !AND: nest child query in parent's "must not"
-        qs.Current().MustNot(out.BoolQ)
-      } else {
-        // AND: nest child query in parent's "must"
-        qs.Current().Must(out.BoolQ)
-      }
-
-    case Or, DefaultOr:
-      if out.Negate {
-        // !OR: nest child query in "should" inside parent's "must not"
-        qs.Current().MustNot(elastic.NewBoolQuery().Should(out.BoolQ))
-      } else {
-        // OR: nest child query in parent's "should"
-        qs.Current().Should(out.BoolQ)
-      }
-    }
+    // child (nested) subqueries are nested in the parent level when it's composed, once its
+    // operator is final and it's known whether +/- prefixes made its unprefixed clauses optional
+    parent := qs.Current()
+    parent.children = append(parent.children, out)
   } else {
     // restack "out" if this is the base level query, as there could
     // be multiple visits to that level before end-of-input
@@ -170,3 +406,65 @@ func (qs *QueryStack) Pop() *Query {
   return out
 }
 
+// nests a closed child level in the parent's proper bucket, for the parent's operator
+func (qs *QueryStack) nest(parent *Query, oper Oper, out *Query) {
+  // a -prohibited group is negated too so "-NOT field:( ... )" cancels out. in strict mode,
+  // negated field groups become a positive "field exists but doesn't match" clause
+  r, negate := out.Rendered(), out.Negate != (out.Occur == Prohibited)
+  if negate && qs.StrictNegation && out.Scope != nil {
+    if guarded := strictNot(r, out.Scope); guarded != nil {
+      r, negate = guarded, false
+    }
+  }
+
+  if out.Occur != Optional {
+    // +required groups nest in the parent's "must" and -prohibited ones in its "must not"
+    if oper == Best {
+      qs.abort(fmt.Errorf("+/- prefixes can't be used inside BEST( ... ) groups, found one on a field group"))
+    }
+    if negate {
+      parent.MustNot(r)
+    } else {
+      parent.Must(r)
+    }
+    return
+  }
+
+  switch oper {
+  case And, DefaultAnd:
+    if negate {
+      // !AND: nest child query in parent's "must not"
+      parent.MustNot(r)
+    } else if out.Filtered {
+      // FILTER: nest child query in parent's "filter"
+      parent.Filter(r)
+    } else {
+      // AND: nest child query in parent's "must"
+      parent.Must(r)
+    }
+
+  case Or, DefaultOr:
+    if negate {
+      // !OR: nest child query in "should" inside parent's "must not"
+      parent.MustNot(elastic.NewBoolQuery().Should(r))
+    } else if out.Filtered {
+      // FILTER in OR: nest child query in a "filter" inside parent's "should"
+      parent.Should(elastic.NewBoolQuery().Filter(r))
+    } else {
+      // OR: nest child query in parent's "should"
+      parent.Should(r)
+    }
+
+  case Best:
+    if negate {
+      // !BEST: nest child query in "must not" inside parent's dis_max
+      parent.Best(elastic.NewBoolQuery().MustNot(r))
+    } else if out.Filtered {
+      // FILTER in BEST: nest child query in a "filter" inside parent's dis_max
+      parent.Best(elastic.NewBoolQuery().Filter(r))
+    } else {
+      // BEST: nest child query in parent's dis_max
+      parent.Best(r)
+    }
+  }
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+
+  "gopkg.in/olivere/elastic.v5"
+)
+
+
+// field_value_factor modifiers, usable as RANK BY function names: "RANK BY log1p(popularity)"
+var factorModifiers = map[string]bool{
+  "none": true, "log": true, "log1p": true, "log2p": true, "ln": true,
+  "ln1p": true, "ln2p": true, "square": true, "sqrt": true, "reciprocal": true,
+}
+
+// parses a single RANK BY function into an ES score function. decays take a field and
+// key=value args: "decay(created_at, origin=now, scale=7d)", or gauss/exp/linear to pick
+// the curve (decay is an alias for gauss). field value factors take a field and optional
+// factor, missing and weight args: "log1p(popularity, factor=1.2)", or factor(...) for none.
+func ParseRankFunction(fn string) (elastic.ScoreFunction, error) {
+  lparen, rparen := strings.Index(fn, "("), strings.LastIndex(fn, ")")
+  if lparen < 0 || rparen < lparen {
+    return nil, fmt.Errorf("malformed rank function %q, expected name(field, key=value, ...)", fn)
+  }
+  name := strings.TrimSpace(fn[:lparen])
+
+  var field string
+  args := map[string]string{}
+  for ndx, arg := range strings.Split(fn[lparen + 1:rparen], ",") {
+    arg = strings.TrimSpace(arg)
+    kv := strings.SplitN(arg, "=", 2)
+    switch {
+    case ndx == 0 && len(kv) == 1 && arg != "":
+      field = arg
+    case len(kv) == 2 && strings.TrimSpace(kv[0]) != "" && strings.TrimSpace(kv[1]) != "":
+      args[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+    default:
+      return nil, fmt.Errorf("rank function %q: expected a field followed by key=value args, got %q", fn, arg)
+    }
+  }
+  if field == "" {
+    return nil, fmt.Errorf("rank function %q requires a field as its first argument", fn)
+  }
+
+  switch {
+  case name == "decay" || name == "gauss" || name == "exp" || name == "linear":
+    return decayFunction(fn, name, field, args)
+  case name == "factor" || factorModifiers[name]:
+    return factorFunction(fn, name, field, args)
+  default:
+    return nil, fmt.Errorf("unknown rank function %q, expected decay, gauss, exp, linear, factor or a field value modifier like log1p", name)
+  }
+}
+
+func decayFunction(fn, name, field string, args map[string]string) (elastic.ScoreFunction, error) {
+  if args["origin"] == "" || args["scale"] == "" {
+    return nil, fmt.Errorf("rank function %q requires origin= and scale= args", fn)
+  }
+  origin, scale := numberOrString(args["origin"]), numberOrString(args["scale"])
+
+  var decay, weight *float64
+  var err error
+  for key, value := range args {
+    switch key {
+    case "origin", "scale", "offset":
+    case "decay":
+      if decay, err = parseArgFloat(fn, key, value); err != nil {
+        return nil, err
+      }
+    case "weight":
+      if weight, err = parseArgFloat(fn, key, value); err != nil {
+        return nil, err
+      }
+    default:
+      return nil, fmt.Errorf("rank function %q: unknown arg %q, expected origin, scale, offset, decay or weight", fn, key)
+    }
+  }
+
+  // the three decay curves share a builder API but not a type
+  switch name {
+  case "exp":
+    df := elastic.NewExponentialDecayFunction().FieldName(field).Origin(origin).Scale(scale)
+    if offset, ok := args["offset"]; ok {
+      df.Offset(numberOrString(offset))
+    }
+    if decay != nil {
+      df.Decay(*decay)
+    }
+    if weight != nil {
+      df.Weight(*weight)
+    }
+    return df, nil
+
+  case "linear":
+    df := elastic.NewLinearDecayFunction().FieldName(field).Origin(origin).Scale(scale)
+    if offset, ok := args["offset"]; ok {
+      df.Offset(numberOrString(offset))
+    }
+    if decay != nil {
+      df.Decay(*decay)
+    }
+    if weight != nil {
+      df.Weight(*weight)
+    }
+    return df, nil
+
+  default:
+    df := elastic.NewGaussDecayFunction().FieldName(field).Origin(origin).Scale(scale)
+    if offset, ok := args["offset"]; ok {
+      df.Offset(numberOrString(offset))
+    }
+    if decay != nil {
+      df.Decay(*decay)
+    }
+    if weight != nil {
+      df.Weight(*weight)
+    }
+    return df, nil
+  }
+}
+
+func factorFunction(fn, name, field string, args map[string]string) (elastic.ScoreFunction, error) {
+  ff := elastic.NewFieldValueFactorFunction().Field(field)
+  if name != "factor" {
+    ff.Modifier(name)
+  }
+
+  for key, value := range args {
+    switch key {
+    case "factor", "missing", "weight":
+    case "modifier":
+      return nil, fmt.Errorf("rank function %q: use the modifier as the function name, i.e. %s(%s)", fn, value, field)
+    default:
+      return nil, fmt.Errorf("rank function %q: unknown arg %q, expected factor, missing or weight", fn, key)
+    }
+
+    f, err := parseArgFloat(fn, key, value)
+    if err != nil {
+      return nil, err
+    }
+    switch key {
+    case "factor":
+      ff.Factor(*f)
+    case "missing":
+      ff.Missing(*f)
+    case "weight":
+      ff.Weight(*f)
+    }
+  }
+
+  return ff, nil
+}
+
+func parseArgFloat(fn, key, value string) (*float64, error) {
+  f, err := strconv.ParseFloat(value, 64)
+  if err != nil {
+    return nil, fmt.Errorf("rank function %q: arg %s must be a number, got %q", fn, key, value)
+  }
+  return &f, nil
+}
+
+// origins and scales are numbers for numeric fields, or strings like "now" and "7d" for dates and geo points
+func numberOrString(value string) interface{} {
+  if f, err := strconv.ParseFloat(value, 64); err == nil {
+    return f
+  }
+  return value
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+  "encoding/json"
+  "strings"
+  "testing"
+)
+
+
+func TestParseRankFunction(t *testing.T) {
+  cases := []struct {
+    fn            string
+    name          string
+    expected      string
+  }{
+    {"decay(created_at, origin=now, scale=7d)", "gauss", `{"created_at":{"origin":"now","scale":"7d"}}`},
+    {"exp(price, origin=0, scale=20, offset=5, decay=0.3)", "exp", `{"price":{"decay":0.3,"offset":5,"origin":0,"scale":20}}`},
+    {"linear(loc, origin=now, scale=1d)", "linear", `{"loc":{"origin":"now","scale":"1d"}}`},
+    {"log1p(popularity)", "field_value_factor", `{"field":"popularity","modifier":"log1p"}`},
+    {"factor(popularity, factor=1.2, missing=1)", "field_value_factor", `{"factor":1.2,"field":"popularity","missing":1}`},
+  }
+
+  for _, c := range cases {
+    fn, err := ParseRankFunction(c.fn)
+    if err != nil {
+      t.Errorf("ParseRankFunction(%q) failed: %s", c.fn, err)
+      continue
+    }
+    src, err := fn.Source()
+    if err != nil {
+      t.Errorf("ParseRankFunction(%q) failed to render: %s", c.fn, err)
+      continue
+    }
+    actual, _ := json.Marshal(src)
+    if fn.Name() != c.name || string(actual) != c.expected {
+      t.Errorf("ParseRankFunction(%q) = %s %s, expected %s %s", c.fn, fn.Name(), actual, c.name, c.expected)
+    }
+  }
+}
+
+func TestParseRankFunctionErrors(t *testing.T) {
+  cases := []struct {
+    fn            string
+    expected      string
+  }{
+    {"popularity", "malformed rank function"},
+    {"log1p()", "expected a field followed by key=value args"},
+    {"decay(origin=now, scale=7d)", "requires a field as its first argument"},
+    {"decay(created_at, scale=7d)", "requires origin= and scale= args"},
+    {"decay(created_at, origin=now, scale=7d, decay=x)", "arg decay must be a number"},
+    {"decay(created_at, origin=now, scale=7d, foo=1)", "unknown arg \"foo\""},
+    {"log1p(popularity, modifier=sqrt)", "use the modifier as the function name"},
+    {"log1p(popularity, factor)", "expected a field followed by key=value args"},
+    {"boost(popularity)", "unknown rank function \"boost\""},
+  }
+
+  for _, c := range cases {
+    _, err := ParseRankFunction(c.fn)
+    if err == nil {
+      t.Errorf("ParseRankFunction(%q) succeeded, expected error %q", c.fn, c.expected)
+      continue
+    }
+    if !strings.Contains(err.Error(), c.expected) {
+      t.Errorf("ParseRankFunction(%q) error = %q, expected %q", c.fn, err, c.expected)
+    }
+  }
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+
+  "gopkg.in/olivere/elastic.v5"
+)
+
+
+// a custom value function, i.e. "geohash" for "loc:geohash(u4pruy)". it's called with the
+// target field and the comma-separated args, once per field for multi-field targets
+type Function func(field string, args []string) (elastic.Query, error)
+
+// a custom infix operator, i.e. "~=" for "name ~= jon". it's called with the key and the right-hand value
+type Operator func(field, value string) (elastic.Query, error)
+
+var (
+  functions = map[string]Function{}
+  operators = map[string]Operator{}
+)
+
+// names the grammar resolves itself, so functions registered under them would never be called
+var reservedFunctions = map[string]bool{
+  "date": true, "ip": true, "num": true, "str": true,
+}
+
+// custom operators are built from these chars, and must start with one of the first two so
+// they don't clash with the DSL's own operators
+const (
+  operatorStartChars = "~%"
+  operatorChars      = "~%^=<>!&|"
+)
+
+// registers a value function, resolved when the parsed query is translated. names are
+// lowercase identifiers, and must be registered before translation starts. like http.Handle,
+// it panics on invalid, reserved or duplicate names, as those are programming errors
+func RegisterFunction(name string, fn Function) {
+  if !isFunctionName(name) || reservedFunctions[name] {
+    panic(fmt.Sprintf("can't register function %q, names must be lowercase identifiers other than %s", name, strings.Join(sortedKeys(reservedFunctions), ", ")))
+  }
+  if fn == nil {
+    panic(fmt.Sprintf("can't register nil function %q", name))
+  }
+  if _, found := functions[name]; found {
+    panic(fmt.Sprintf("function %q is already registered", name))
+  }
+  functions[name] = fn
+}
+
+// registers an infix operator like "~=", resolved when the parsed query is translated. it panics
+// on invalid or duplicate operators, like RegisterFunction
+func RegisterOperator(op string, fn Operator) {
+  if op == "" || !strings.ContainsRune(operatorStartChars, rune(op[0])) || strings.Trim(op, operatorChars) != "" {
+    panic(fmt.Sprintf("can't register operator %q, operators must start with one of %q and only contain %q", op, operatorStartChars, operatorChars))
+  }
+  if fn == nil {
+    panic(fmt.Sprintf("can't register nil operator %q", op))
+  }
+  if _, found := operators[op]; found {
+    panic(fmt.Sprintf("operator %q is already registered", op))
+  }
+  operators[op] = fn
+}
+
+func isFunctionName(name string) bool {
+  for ndx, r := range name {
+    if !(r == '_' || (r >= 'a' && r <= 'z') || (ndx > 0 && r >= '0' && r <= '9')) {
+      return false
+    }
+  }
+  return name != ""
+}
+
+// splits a function call like `score(high, "a, b")` into its name and args, unquoting quoted args
+func parseCall(call string) (string, []string, error) {
+  lparen := strings.Index(call, "(")
+  if lparen < 0 || !strings.HasSuffix(call, ")") {
+    return "", nil, fmt.Errorf("malformed function call %q, expected name(args, ...)", call)
+  }
+
+  args := []string{}
+  body := strings.TrimSpace(call[lparen + 1:len(call) - 1])
+  for body != "" {
+    end := strings.Index(body, ",")
+    if body[0] == '"' {
+      if end = strings.Index(body[1:], `"`) + 2; end < 2 {
+        return "", nil, fmt.Errorf("unterminated quoted arg in function call %q", call)
+      }
+      args = append(args, body[1:end - 1])
+    } else {
+      if end < 0 {
+        end = len(body)
+      }
+      args = append(args, strings.TrimSpace(body[:end]))
+    }
+
+    body = strings.TrimSpace(body[end:])
+    if strings.HasPrefix(body, ",") {
+      body = strings.TrimSpace(body[1:])
+    } else if body != "" {
+      return "", nil, fmt.Errorf("expected a comma between args in function call %q", call)
+    }
+  }
+
+  return call[:lparen], args, nil
+}
+
+func sortedKeys(m interface{}) []string {
+  keys := []string{}
+  switch typed := m.(type) {
+  case map[string]bool:
+    for key := range typed {
+      keys = append(keys, key)
+    }
+  case map[string]Function:
+    for key := range typed {
+      keys = append(keys, key)
+    }
+  case map[string]Operator:
+    for key := range typed {
+      keys = append(keys, key)
+    }
+  }
+  sort.Strings(keys)
+
+  return keys
+}
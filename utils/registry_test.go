@@ -0,0 +1,136 @@
+package utils
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+
+  "gopkg.in/olivere/elastic.v5"
+)
+
+
+func testFunction(field string, args []string) (elastic.Query, error) {
+  return elastic.NewTermQuery(field, strings.Join(args, ",")), nil
+}
+
+func testOperator(field, value string) (elastic.Query, error) {
+  return elastic.NewTermQuery(field, value), nil
+}
+
+// returns the panic raised by register, or "" if it didn't panic
+func registerPanic(register func()) (msg string) {
+  defer func() {
+    if r := recover(); r != nil {
+      msg = r.(string)
+    }
+  }()
+  register()
+  return ""
+}
+
+func TestRegisterFunction(t *testing.T) {
+  if msg := registerPanic(func() { RegisterFunction("test_fn1", testFunction) }); msg != "" {
+    t.Fatalf("RegisterFunction(\"test_fn1\") panicked: %s", msg)
+  }
+  if _, found := functions["test_fn1"]; !found {
+    t.Errorf("RegisterFunction(\"test_fn1\") didn't register the function")
+  }
+
+  cases := []struct {
+    name          string
+    fn            Function
+    expected      string
+  }{
+    {"", testFunction, "names must be lowercase identifiers"},
+    {"Geo", testFunction, "names must be lowercase identifiers"},
+    {"1geo", testFunction, "names must be lowercase identifiers"},
+    {"geo-hash", testFunction, "names must be lowercase identifiers"},
+    {"date", testFunction, "other than date, ip, num, str"},
+    {"str", testFunction, "other than date, ip, num, str"},
+    {"test_fn2", nil, "can't register nil function"},
+    {"test_fn1", testFunction, "function \"test_fn1\" is already registered"},
+  }
+
+  for _, c := range cases {
+    msg := registerPanic(func() { RegisterFunction(c.name, c.fn) })
+    if !strings.Contains(msg, c.expected) {
+      t.Errorf("RegisterFunction(%q) panic = %q, expected %q", c.name, msg, c.expected)
+    }
+  }
+}
+
+func TestRegisterOperator(t *testing.T) {
+  if msg := registerPanic(func() { RegisterOperator("~~=", testOperator) }); msg != "" {
+    t.Fatalf("RegisterOperator(\"~~=\") panicked: %s", msg)
+  }
+  if _, found := operators["~~="]; !found {
+    t.Errorf("RegisterOperator(\"~~=\") didn't register the operator")
+  }
+
+  cases := []struct {
+    op            string
+    fn            Operator
+    expected      string
+  }{
+    {"", testOperator, "operators must start with one of"},
+    {"^=", testOperator, "operators must start with one of"},
+    {"=~", testOperator, "operators must start with one of"},
+    {"~a", testOperator, "only contain"},
+    {"%%", nil, "can't register nil operator"},
+    {"~~=", testOperator, "operator \"~~=\" is already registered"},
+  }
+
+  for _, c := range cases {
+    msg := registerPanic(func() { RegisterOperator(c.op, c.fn) })
+    if !strings.Contains(msg, c.expected) {
+      t.Errorf("RegisterOperator(%q) panic = %q, expected %q", c.op, msg, c.expected)
+    }
+  }
+}
+
+func TestParseCall(t *testing.T) {
+  cases := []struct {
+    call          string
+    name          string
+    args          []string
+  }{
+    {"geohash(u4pruy)", "geohash", []string{"u4pruy"}},
+    {"score()", "score", []string{}},
+    {"score( high , low )", "score", []string{"high", "low"}},
+    {`score("a, b", c)`, "score", []string{"a, b", "c"}},
+    {`score(c, "a (b)")`, "score", []string{"c", "a (b)"}},
+    {`score("  spaced  ")`, "score", []string{"  spaced  "}},
+  }
+
+  for _, c := range cases {
+    name, args, err := parseCall(c.call)
+    if err != nil {
+      t.Errorf("parseCall(%q) failed: %s", c.call, err)
+      continue
+    }
+    if name != c.name || !reflect.DeepEqual(args, c.args) {
+      t.Errorf("parseCall(%q) = %s %q, expected %s %q", c.call, name, args, c.name, c.args)
+    }
+  }
+
+  errors := []struct {
+    call          string
+    expected      string
+  }{
+    {"score", "malformed function call"},
+    {"score(a", "malformed function call"},
+    {`score("a, b)`, "unterminated quoted arg"},
+    {`score("a" b)`, "expected a comma between args"},
+  }
+
+  for _, c := range errors {
+    _, _, err := parseCall(c.call)
+    if err == nil {
+      t.Errorf("parseCall(%q) succeeded, expected error %q", c.call, c.expected)
+      continue
+    }
+    if !strings.Contains(err.Error(), c.expected) {
+      t.Errorf("parseCall(%q) error = %q, expected %q", c.call, err, c.expected)
+    }
+  }
+}
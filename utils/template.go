@@ -0,0 +1,47 @@
+package utils
+
+import (
+  "encoding/json"
+  "sort"
+  "strings"
+)
+
+
+// the mustache slot for a scalar placeholder. it's rendered inside a JSON string, so ES coerces the
+// bound value to the field's type as it would any quoted value
+func ValueSlot(name string) string {
+  return "{{" + name + "}}"
+}
+
+// the mustache slot for a list placeholder, rendering the bound list as a JSON array
+func ListSlot(name string) string {
+  return "{{#toJson}}" + name + "{{/toJson}}"
+}
+
+// wraps the rendered query JSON (holding placeholder slots) into a search template: the template
+// source as a string, as list slots aren't valid JSON until rendered, and a params skeleton holding
+// the bound values if any, or else empty values. slots maps each placeholder name to whether it's a list
+func SearchTemplate(query string, slots map[string]bool, bound map[string]interface{}) (string, error) {
+  names := []string{}
+  for name := range slots {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  params := map[string]interface{}{}
+  for _, name := range names {
+    if slots[name] {
+      // the list slot was rendered as a single-item list of strings, unwrap it for toJson
+      query = strings.Replace(query, `["` + ListSlot(name) + `"]`, ListSlot(name), -1)
+      params[name] = []interface{}{}
+    } else {
+      params[name] = ""
+    }
+    if value, found := bound[name]; found {
+      params[name] = value
+    }
+  }
+
+  out, err := json.Marshal(map[string]interface{}{"template": query, "params": params})
+  return string(out), err
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+
+  "gopkg.in/olivere/elastic.v5"
+)
+
+
+// parses an "IN" list like `(1, 2, "three, four", five)` into its values. numeric items are
+// numbers, quoted items are always strings
+func ParseTermsList(list string) ([]interface{}, error) {
+  body := strings.TrimSpace(list)
+  if !strings.HasPrefix(body, "(") || !strings.HasSuffix(body, ")") {
+    return nil, fmt.Errorf("malformed IN list %s, expected (value, ...)", list)
+  }
+  body = strings.TrimSpace(body[1:len(body) - 1])
+
+  out := []interface{}{}
+  for body != "" {
+    var item string
+    if body[0] == '"' {
+      end := 1
+      for ; end < len(body) && body[end] != '"'; end++ {
+        if body[end] == '\\' {
+          end++
+        }
+      }
+      if end >= len(body) {
+        return nil, fmt.Errorf("unterminated quoted value in IN list %s", list)
+      }
+      out = append(out, Unescape(body[1:end]))
+      body = strings.TrimSpace(body[end + 1:])
+    } else {
+      end := strings.Index(body, ",")
+      if end < 0 {
+        end = len(body)
+      }
+      if item = strings.TrimSpace(body[:end]); item == "" {
+        return nil, fmt.Errorf("empty value in IN list %s", list)
+      }
+      if num, err := strconv.ParseFloat(item, 10); err == nil {
+        out = append(out, num)
+      } else {
+        out = append(out, item)
+      }
+      body = body[end:]
+    }
+
+    if body != "" {
+      if body[0] != ',' {
+        return nil, fmt.Errorf("expected a comma between values in IN list %s", list)
+      }
+      if body = strings.TrimSpace(body[1:]); body == "" {
+        return nil, fmt.Errorf("trailing comma in IN list %s", list)
+      }
+    }
+  }
+  if len(out) == 0 {
+    return nil, fmt.Errorf("IN list %s must hold at least one value", list)
+  }
+
+  return out, nil
+}
+
+// parses an "IN @( ... )" doc reference like "users/team/eng#members" into a terms lookup
+// fetching the value list from the "members" field of doc "eng" (of type "team" in index "users")
+func ParseTermsLookup(ref string) (*elastic.TermsLookup, error) {
+  docPath := strings.SplitN(ref, "#", 2)
+  if len(docPath) != 2 || docPath[1] == "" {
+    return nil, fmt.Errorf("terms lookup @(%s) needs a #path to the field holding the values, i.e. @(index/type/id#path)", ref)
+  }
+
+  doc := strings.Split(docPath[0], "/")
+  if len(doc) != 3 || doc[0] == "" || doc[1] == "" || doc[2] == "" {
+    return nil, fmt.Errorf("malformed terms lookup @(%s), expected @(index/type/id#path)", ref)
+  }
+
+  return elastic.NewTermsLookup().Index(doc[0]).Type(doc[1]).Id(doc[2]).Path(docPath[1]), nil
+}
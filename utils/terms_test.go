@@ -0,0 +1,57 @@
+package utils
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+)
+
+
+func TestParseTermsList(t *testing.T) {
+  cases := []struct {
+    list          string
+    expected      []interface{}
+  }{
+    {"(a)", []interface{}{"a"}},
+    {"(1, 2.5, three)", []interface{}{1.0, 2.5, "three"}},
+    {`( "three, four" , five )`, []interface{}{"three, four", "five"}},
+    {`("123", 123)`, []interface{}{"123", 123.0}},
+    {`("say \"hi\"")`, []interface{}{`say "hi"`}},
+  }
+
+  for _, c := range cases {
+    actual, err := ParseTermsList(c.list)
+    if err != nil {
+      t.Errorf("ParseTermsList(%q) failed: %s", c.list, err)
+      continue
+    }
+    if !reflect.DeepEqual(actual, c.expected) {
+      t.Errorf("ParseTermsList(%q) = %#v, expected %#v", c.list, actual, c.expected)
+    }
+  }
+}
+
+func TestParseTermsListErrors(t *testing.T) {
+  cases := []struct {
+    list          string
+    expected      string
+  }{
+    {"a, b", "malformed IN list"},
+    {"()", "must hold at least one value"},
+    {"(a, , b)", "empty value in IN list"},
+    {"(a, b,)", "trailing comma in IN list"},
+    {`("a" b)`, "expected a comma between values"},
+    {`("a, b)`, "unterminated quoted value"},
+  }
+
+  for _, c := range cases {
+    _, err := ParseTermsList(c.list)
+    if err == nil {
+      t.Errorf("ParseTermsList(%q) succeeded, expected error %q", c.list, c.expected)
+      continue
+    }
+    if !strings.Contains(err.Error(), c.expected) {
+      t.Errorf("ParseTermsList(%q) error = %q, expected %q", c.list, err, c.expected)
+    }
+  }
+}
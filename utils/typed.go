@@ -0,0 +1,67 @@
+package utils
+
+import (
+  "fmt"
+  "net"
+  "strconv"
+  "strings"
+  "time"
+)
+
+
+// date formats accepted by date("..."), besides ES date math like "now-7d/d"
+var typedDateFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// parses a typed literal like `date("2017-11-29")`, `ip(10.0.0.0/8)`, `num("1e3")` or `str(02134)`,
+// forcing the literal's type rather than guessing it from its shape. returns the type name and value
+func ParseTypedLiteral(literal string) (string, interface{}, error) {
+  lparen := strings.Index(literal, "(")
+  if lparen < 0 || !strings.HasSuffix(literal, ")") {
+    return "", nil, fmt.Errorf("malformed typed literal %q, expected type(value)", literal)
+  }
+  kind := literal[:lparen]
+  arg := strings.TrimSpace(literal[lparen + 1:len(literal) - 1])
+  if strings.HasPrefix(arg, `"`) {
+    arg = Unescape(arg[1:len(arg) - 1])
+  }
+  if arg == "" {
+    return "", nil, fmt.Errorf("typed literal %q has an empty value", literal)
+  }
+
+  switch kind {
+  case "str":
+    return kind, arg, nil
+
+  case "num":
+    num, err := strconv.ParseFloat(arg, 10)
+    if err != nil {
+      return "", nil, fmt.Errorf("num(...) literal %q is not a number", arg)
+    }
+    return kind, num, nil
+
+  case "ip":
+    if net.ParseIP(arg) == nil {
+      if _, _, err := net.ParseCIDR(arg); err != nil {
+        return "", nil, fmt.Errorf("ip(...) literal %q is not an IPv4/IPv6 address or CIDR block", arg)
+      }
+    }
+    return kind, arg, nil
+
+  case "date":
+    if strings.HasPrefix(arg, "now") {
+      return kind, arg, nil
+    }
+    for _, layout := range typedDateFormats {
+      if t, err := time.Parse(layout, arg); err == nil {
+        if layout == time.RFC3339 {
+          return kind, t, nil
+        }
+        return kind, arg, nil
+      }
+    }
+    return "", nil, fmt.Errorf("date(...) literal %q must be RFC3339, yyyy-MM-dd['T'HH:mm:ss] or date math like now-7d", arg)
+
+  default:
+    return "", nil, fmt.Errorf("unknown literal type %q in %q, expected date, ip, num or str", kind, literal)
+  }
+}
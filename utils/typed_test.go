@@ -0,0 +1,66 @@
+package utils
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+)
+
+
+func TestParseTypedLiteral(t *testing.T) {
+  cases := []struct {
+    literal       string
+    kind          string
+    expected      interface{}
+  }{
+    {"str(02134)", "str", "02134"},
+    {`str("a \"b\" c")`, "str", `a "b" c`},
+    {`num("1e3")`, "num", 1000.0},
+    {"num( -2.5 )", "num", -2.5},
+    {"ip(10.0.0.1)", "ip", "10.0.0.1"},
+    {"ip(10.0.0.0/8)", "ip", "10.0.0.0/8"},
+    {"ip(::1)", "ip", "::1"},
+    {`date("2017-11-29")`, "date", "2017-11-29"},
+    {"date(2017-11-29T10:00:00)", "date", "2017-11-29T10:00:00"},
+    {"date(2017-11-29T10:00:00Z)", "date", time.Date(2017, 11, 29, 10, 0, 0, 0, time.UTC)},
+    {"date(now-7d/d)", "date", "now-7d/d"},
+  }
+
+  for _, c := range cases {
+    kind, actual, err := ParseTypedLiteral(c.literal)
+    if err != nil {
+      t.Errorf("ParseTypedLiteral(%q) failed: %s", c.literal, err)
+      continue
+    }
+    if kind != c.kind || !reflect.DeepEqual(actual, c.expected) {
+      t.Errorf("ParseTypedLiteral(%q) = %s %#v, expected %s %#v", c.literal, kind, actual, c.kind, c.expected)
+    }
+  }
+}
+
+func TestParseTypedLiteralErrors(t *testing.T) {
+  cases := []struct {
+    literal       string
+    expected      string
+  }{
+    {"str", "malformed typed literal"},
+    {"str()", "has an empty value"},
+    {`str("")`, "has an empty value"},
+    {"num(abc)", "is not a number"},
+    {"ip(10.0.0.256)", "is not an IPv4/IPv6 address or CIDR block"},
+    {"date(yesterday)", "must be RFC3339"},
+    {"bool(true)", "unknown literal type \"bool\""},
+  }
+
+  for _, c := range cases {
+    _, _, err := ParseTypedLiteral(c.literal)
+    if err == nil {
+      t.Errorf("ParseTypedLiteral(%q) succeeded, expected error %q", c.literal, c.expected)
+      continue
+    }
+    if !strings.Contains(err.Error(), c.expected) {
+      t.Errorf("ParseTypedLiteral(%q) error = %q, expected %q", c.literal, err, c.expected)
+    }
+  }
+}
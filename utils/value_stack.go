@@ -1,7 +1,10 @@
 package utils
 
 import (
+  "encoding/json"
+  "fmt"
   "log"
+  "reflect"
   "strconv"
   "strings"
   "time"
@@ -24,32 +27,112 @@ const (
   GreaterThanEqual
 )
 
+// the same comparison, read from the other side: "10 <= price" is "price >= 10"
+func (r RangeOp) Flip() RangeOp {
+  switch r {
+  case LessThan:         return GreaterThan
+  case LessThanEqual:    return GreaterThanEqual
+  case GreaterThan:      return LessThan
+  case GreaterThanEqual: return LessThanEqual
+  default:               return NoOp
+  }
+}
+
+func (r RangeOp) IsLower() bool {
+  return r == GreaterThan || r == GreaterThanEqual
+}
+
+// the comparison operator, as written in the DSL and in painless scripts
+func (r RangeOp) Symbol() string {
+  switch r {
+  case LessThan:         return "<"
+  case LessThanEqual:    return "<="
+  case GreaterThan:      return ">"
+  case GreaterThanEqual: return ">="
+  default:               return ""
+  }
+}
+
+// painless source for field-to-field comparisons. fields are passed as params, and
+// docs missing either field don't match rather than failing the script
+const fieldCompareScript = "doc[params.left].size() != 0 && doc[params.right].size() != 0 && doc[params.left].value %s doc[params.right].value"
+
+// Lucene-style "+required" and "-prohibited" term prefixes
+type Occur uint8
+const (
+  Optional      Occur = iota
+  Required
+  Prohibited
+)
+
 type Value struct {
   Q             elastic.Query
   Field         string
+  // set when the value targets several fields, i.e. "(title^3,body):x" or "user.*:x". Field
+  // then holds the comma-joined list, for display only
+  Fields        []string
+  // the text of a quoted phrase, kept so modifiers can re-render it as a match query
+  Phrase        string
   RangeOp       RangeOp
+  // the first bound of a chained comparison like "10 <= price < 100", already flipped to read "price >= 10"
+  BoundOp       RangeOp
+  Bound         interface{}
   Negate        bool
+  Occur         Occur
+  // the value's field(s) came from a key, not the defaults
+  Keyed         bool
 }
 
 var (
   // sentinel value marking the start of the "current" nested AND/OR clause, for stacking
-  GroupInit = &Value{nil, GroupInitField, NoOp, false}
+  GroupInit = &Value{nil, GroupInitField, nil, "", NoOp, NoOp, nil, false, Optional, false}
   // sentinel value for Value with as-yet-unset elastic.Query field
   NoQuery elastic.Query = nil
 )
 
 func NewValue(negate bool) *Value {
-  return &Value{NoQuery, NoField, NoOp, negate}
+  return &Value{NoQuery, NoField, nil, "", NoOp, NoOp, nil, negate, Optional, false}
 }
 
 type ValueStack struct {
-  stack         []*Value
-  Default       string
+  stack           []*Value
+  // enclosing "field:( ... )" groups, innermost last. keyless values inside target its field(s)
+  scopes          []*Value
+  // fields targeted by values parsed without a key, with optional "^N" boosts
+  Defaults        []string
+  // multi_match type rendered for values targeting several fields (best_fields if unset)
+  MultiMatchType  string
+  // policy switches rejecting the RAW{...} and QS"..." escape hatches
+  DisableRaw          bool
+  DisableQueryString  bool
+  // the whole query is in filter context (--filter), so values render as terms rather than matches
+  Filtered        bool
+  // depth of enclosing FILTER( ... ) groups, whose values are in filter context too
+  filters         int
+  // values bound to "${name}" placeholders
+  Params          map[string]interface{}
+  // render placeholders as mustache slots for a search template, rather than binding them
+  Template        bool
+  // placeholders seen in template mode, by name: true for lists
+  slots           map[string]bool
+  // collect placeholder bind and type errors and registry errors in Errors, rather than exiting
+  Collect         bool
+  Errors          []error
 }
 
-func (vs *ValueStack) Init(defField string) {
+// defFields is a comma-separated list of default fields, i.e. "title^3,summary^2,body"
+func (vs *ValueStack) Init(defFields string) {
   vs.stack = []*Value{}
-  vs.Default = defField
+  vs.scopes = []*Value{}
+  vs.Defaults = []string{}
+  for _, field := range strings.Split(defFields, ",") {
+    if field = strings.TrimSpace(field); field != "" {
+      vs.Defaults = append(vs.Defaults, field)
+    }
+  }
+  if len(vs.Defaults) == 0 {
+    vs.abort(fmt.Errorf("at least one default field is required, got %q", defFields))
+  }
 }
 
 func (vs *ValueStack) Push(v *Value) {
@@ -90,6 +173,40 @@ func (vs *ValueStack) StartGroup() {
   vs.Push(GroupInit)
 }
 
+// opens a "field:( ... )" group: the tmp value holding the key becomes the scope for the
+// group's keyless values. returns the scope, whose negation and +/- prefix apply to the whole group
+func (vs *ValueStack) StartFieldGroup() *Value {
+  scope := vs.current()
+  vs.scopes = append(vs.scopes, scope)
+  vs.StartGroup()
+
+  return scope
+}
+
+func (vs *ValueStack) EndFieldGroup() {
+  if len(vs.scopes) == 0 {
+    vs.abort(fmt.Errorf("can't close field group - no field group is open"))
+  }
+  vs.scopes = vs.scopes[:len(vs.scopes) - 1]
+}
+
+// opens a FILTER( ... ) group: values parsed until the matching EndFilter are in filter context
+func (vs *ValueStack) StartFilter() {
+  vs.filters++
+}
+
+func (vs *ValueStack) EndFilter() {
+  if vs.filters == 0 {
+    vs.abort(fmt.Errorf("can't close filter group - no filter group is open"))
+  }
+  vs.filters--
+}
+
+// true when values parsed now are in filter context, globally or inside a FILTER( ... ) group
+func (vs *ValueStack) inFilter() bool {
+  return vs.Filtered || vs.filters > 0
+}
+
 // returns the group of values for this nested AND/OR block
 func (vs *ValueStack) PopGroup() []*Value {
   out := []*Value{}
@@ -105,19 +222,211 @@ func (vs *ValueStack) PopGroup() []*Value {
   return out
 }
 
-// first thing that happens in Term parsing (if present), so append a dummy value for filling in as we parse
+// first thing that happens in Term parsing (if present, after any +/- prefix), so
+// produce a dummy value for filling in as we parse
 func (vs *ValueStack) SetNegation() {
-  vs.Push(NewValue(true))
+  tmp := vs.current()
+  tmp.Negate = true
+  vs.Push(tmp)
+}
+
+// flips the tmp value's negation, for "field != value" (so "NOT a != b" means "a = b")
+func (vs *ValueStack) InvertNegation() {
+  tmp := vs.current()
+  tmp.Negate = !tmp.Negate
+  vs.Push(tmp)
+}
+
+// "+term" must match and "-term" must not, regardless of the clause's operator
+func (vs *ValueStack) SetOccur(occur Occur) {
+  vs.Push(&Value{NoQuery, NoField, nil, "", NoOp, NoOp, nil, false, occur, false})
 }
 
 // pop the tmp value stacked by SetNegation earlier, or produce
 // new one if not - then fill in Field, replace on stack
 func (vs *ValueStack) SetField(field string) {
+  field = Unescape(field)
+  if strings.Contains(field, "*") {
+    vs.AddField(field)
+    return
+  }
+
+  vs.checkMetaField(field)
   v := vs.current()
   v.Field = field
+  v.Keyed = true
+  vs.Push(v)
+}
+
+// like SetField, but appends to the value's list of target fields, for "(title,body):x" and wildcard keys
+func (vs *ValueStack) AddField(field string) {
+  vs.checkMetaField(Unescape(field))
+  v := vs.current()
+  v.Fields = append(v.Fields, Unescape(field))
+  v.Field = strings.Join(v.Fields, ",")
+  v.Keyed = true
+  vs.Push(v)
+}
+
+// applies a "^N" boost to the most recently added target field
+func (vs *ValueStack) SetFieldBoost(boost string) {
+  v := vs.current()
+  if len(v.Fields) == 0 {
+    vs.abort(fmt.Errorf("boost ^%s must follow a field in a multi-field list", boost))
+  }
+  v.Fields[len(v.Fields) - 1] += "^" + boost
+  v.Field = strings.Join(v.Fields, ",")
   vs.Push(v)
 }
 
+// fills in the field(s) for values parsed without a key: those of the enclosing field group if
+// any, or else the defaults. several defaults, or a boosted one, make the value a multi-field
+// value like "(title^3,body):x" would
+func (vs *ValueStack) target(tmp *Value) {
+  if tmp.Field != NoField {
+    return
+  }
+
+  if len(vs.scopes) > 0 {
+    scope := vs.scopes[len(vs.scopes) - 1]
+    tmp.Field = scope.Field
+    tmp.Fields = append([]string{}, scope.Fields...)
+    tmp.Keyed = true
+    return
+  }
+
+  if len(vs.Defaults) == 1 && !strings.Contains(vs.Defaults[0], "^") {
+    tmp.Field = vs.Defaults[0]
+    return
+  }
+  tmp.Fields = append([]string{}, vs.Defaults...)
+  tmp.Field = strings.Join(tmp.Fields, ",")
+}
+
+// renders a query per target field, joining them in a bool "should" when the value targets several
+func (vs *ValueStack) perField(tmp *Value, wildcards bool, build func(field string) elastic.Query) elastic.Query {
+  vs.rejectMetaFields(tmp, "ranges, wildcards, exists checks or field comparisons")
+  if len(tmp.Fields) == 0 {
+    return build(tmp.Field)
+  }
+
+  bq := elastic.NewBoolQuery()
+  for _, field := range tmp.Fields {
+    if !wildcards && strings.Contains(field, "*") {
+      vs.abort(fmt.Errorf("wildcard field %q is only supported for text, phrase and exists values", field))
+    }
+    // boosts only make sense for scored text queries, drop them here
+    bq.Should(build(strings.SplitN(field, "^", 2)[0]))
+  }
+
+  return bq
+}
+
+// "match" clause against the value's field, or a "multi_match" of the configured type across several
+func (vs *ValueStack) matchQuery(tmp *Value, text interface{}) elastic.Query {
+  if metaFields[tmp.Field] {
+    return vs.metaQuery(tmp.Field, text)
+  }
+  if len(tmp.Fields) > 0 {
+    vs.rejectMetaFields(tmp, "multi-field text values")
+    return elastic.NewMultiMatchQuery(text, tmp.Fields...).Type(vs.MultiMatchType)
+  }
+  return elastic.NewMatchQuery(tmp.Field, text)
+}
+
+// "term" clause against the value's field, or one per field in a bool "should". wildcard
+// field patterns can't be expanded by term queries, so those fall back to a "multi_match".
+// value lists and terms lookups render as "terms" clauses instead
+func (vs *ValueStack) termQuery(tmp *Value, term interface{}) elastic.Query {
+  if metaFields[tmp.Field] {
+    return vs.metaQuery(tmp.Field, term)
+  }
+  if len(tmp.Fields) > 0 {
+    vs.rejectMetaFields(tmp, "multi-field values")
+  }
+  list, isList := term.([]interface{})
+  lookup, isLookup := term.(*elastic.TermsLookup)
+  for _, field := range tmp.Fields {
+    if strings.Contains(field, "*") && !isList && !isLookup {
+      return elastic.NewMultiMatchQuery(term, tmp.Fields...).Type(vs.MultiMatchType)
+    }
+  }
+
+  return vs.perField(tmp, false, func(field string) elastic.Query {
+    switch {
+    case isList:
+      return elastic.NewTermsQuery(field, list...)
+    case isLookup:
+      return elastic.NewTermsQuery(field).TermsLookup(lookup)
+    default:
+      return elastic.NewTermQuery(field, term)
+    }
+  })
+}
+
+// strips the backslashes from escaped chars in quoted or bare field names: "my \"odd\" field" -> my "odd" field
+func Unescape(s string) string {
+  if !strings.Contains(s, `\`) {
+    return s
+  }
+
+  var out strings.Builder
+  escaped := false
+  for _, r := range s {
+    if !escaped && r == '\\' {
+      escaped = true
+      continue
+    }
+    escaped = false
+    out.WriteRune(r)
+  }
+
+  return out.String()
+}
+
+// records the leading "10 <=" of a chained comparison, parsing the bound as a datetime or number
+func (vs *ValueStack) SetLowerBound(boundAndOp string) {
+  tmp := vs.current()
+
+  boundAndOp = strings.TrimSpace(boundAndOp)
+  bound := strings.TrimSpace(strings.TrimRight(boundAndOp, "<>="))
+  switch strings.TrimSpace(boundAndOp[len(bound):]) {
+  case "<":
+    tmp.BoundOp = LessThan.Flip()
+  case "<=":
+    tmp.BoundOp = LessThanEqual.Flip()
+  case ">":
+    tmp.BoundOp = GreaterThan.Flip()
+  case ">=":
+    tmp.BoundOp = GreaterThanEqual.Flip()
+  default:
+    vs.abort(fmt.Errorf("invalid comparison %q in chained range", boundAndOp))
+  }
+
+  if strings.HasPrefix(bound, "${") {
+    // a placeholder bound, i.e. "${lo} <= price < ${hi}"
+    name := bound[2:len(bound) - 1]
+    if vs.Template {
+      vs.slot(name, false)
+      tmp.Bound = ValueSlot(name)
+    } else if value, found := vs.param(name); found {
+      checked, err := paramBound(name, value)
+      if err != nil {
+        vs.fail(err)
+      }
+      tmp.Bound = checked
+    }
+  } else if t, err := time.Parse(time.RFC3339, bound); err == nil {
+    tmp.Bound = t
+  } else if num, err := strconv.ParseFloat(bound, 10); err == nil {
+    tmp.Bound = num
+  } else {
+    vs.abort(fmt.Errorf("chained range bound must be a valid RFC3339 datetime or number, got %q", bound))
+  }
+
+  vs.Push(tmp)
+}
+
 // pop the tmp value stacked by SetNegation and SetField, fill in range op, replace on stack
 func (vs *ValueStack) SetRangeOp(rop RangeOp) {
   tmp := vs.current()
@@ -127,19 +436,23 @@ func (vs *ValueStack) SetRangeOp(rop RangeOp) {
 
 func (vs *ValueStack) Boolean(value string) {
   tmp := vs.current()
+  vs.target(tmp)
 
   b, err := strconv.ParseBool(value)
   if err != nil {
-    log.Fatalf("[ERROR] failed to parse boolean from term %q for field %q, err=%s", value, tmp.Field, err)
+    vs.abort(fmt.Errorf("failed to parse boolean from term %q for field %q, err=%s", value, tmp.Field, err))
   }
 
-  tmp.Q = elastic.NewTermQuery(tmp.Field, b)
+  tmp.Q = vs.termQuery(tmp, b)
   vs.Push(tmp)
 }
 
 func (vs *ValueStack) Exists() {
   tmp := vs.current()
-  tmp.Q = elastic.NewExistsQuery(tmp.Field)
+  vs.target(tmp)
+  tmp.Q = vs.perField(tmp, true, func(field string) elastic.Query {
+    return elastic.NewExistsQuery(field)
+  })
   vs.Push(tmp)
 }
 
@@ -147,14 +460,12 @@ func (vs *ValueStack) Exists() {
 func (vs *ValueStack) Date(filtered bool, value interface{}) {
   tmp := vs.current()
 
-  if tmp.Field == NoField {
-    tmp.Field = vs.Default
-  }
+  vs.target(tmp)
   // drop value into "match" clause for queries, "term" clause in filter context
   if filtered {
-    tmp.Q = elastic.NewTermQuery(tmp.Field, value)
+    tmp.Q = vs.termQuery(tmp, value)
   } else {
-    tmp.Q = elastic.NewMatchQuery(tmp.Field, value)
+    tmp.Q = vs.matchQuery(tmp, value)
   }
 
   vs.Push(tmp)
@@ -163,14 +474,12 @@ func (vs *ValueStack) Date(filtered bool, value interface{}) {
 func (vs *ValueStack) Number(filtered bool, value interface{}) {
   tmp := vs.current()
 
-  if tmp.Field == NoField {
-    tmp.Field = vs.Default
-  }
+  vs.target(tmp)
   // drop value into "match" clause for queries, "term" clause in filter context
   if filtered {
-    tmp.Q = elastic.NewTermQuery(tmp.Field, value)
+    tmp.Q = vs.termQuery(tmp, value)
   } else {
-    tmp.Q = elastic.NewMatchQuery(tmp.Field, value)
+    tmp.Q = vs.matchQuery(tmp, value)
   }
 
   vs.Push(tmp)
@@ -178,36 +487,196 @@ func (vs *ValueStack) Number(filtered bool, value interface{}) {
 
 func (vs *ValueStack) Term(term interface{}) {
   tmp := vs.current()
-  if tmp.Field == NoField {
-    tmp.Field = vs.Default
-  }
-  tmp.Q = elastic.NewTermQuery(tmp.Field, term)
+  vs.target(tmp)
+  tmp.Q = vs.termQuery(tmp, term)
   vs.Push(tmp)
 }
 
+// "IN (a, b, c)" matches any of the listed values, as a "terms" clause in both query and filter context
+func (vs *ValueStack) TermsList(list string) {
+  values, err := ParseTermsList(list)
+  if err != nil {
+    vs.abort(err)
+  }
+  vs.Term(values)
+}
+
+// "IN @(index/type/id#path)" matches any of the values held in another doc's field
+func (vs *ValueStack) TermsLookup(ref string) {
+  lookup, err := ParseTermsLookup(ref)
+  if err != nil {
+    vs.abort(err)
+  }
+  vs.Term(lookup)
+}
+
 func (vs *ValueStack) Match(text interface{}) {
   tmp := vs.current()
-  if tmp.Field == NoField {
-    tmp.Field = vs.Default
-  }
-  tmp.Q = elastic.NewMatchQuery(tmp.Field, text)
+  vs.target(tmp)
+  tmp.Q = vs.matchQuery(tmp, text)
   vs.Push(tmp)
 }
 
 // only used in single-value (quoted phrase) context (i.e. not a KV)
 func (vs *ValueStack) Phrase(phrase string) {
   tmp := vs.current()
-  if tmp.Field == NoField {
-    tmp.Field = vs.Default
+  vs.target(tmp)
+
+  tmp.Phrase = phrase
+  if metaFields[tmp.Field] {
+    // quoted meta-field values are exact values, i.e. `_id:"123"`
+    tmp.Phrase = ""
+    tmp.Q = vs.metaQuery(tmp.Field, phrase)
+  } else if len(tmp.Fields) > 0 {
+    vs.rejectMetaFields(tmp, "multi-field phrases")
+    typ := "phrase"
+    if vs.MultiMatchType == "phrase_prefix" {
+      typ = vs.MultiMatchType
+    }
+    tmp.Q = elastic.NewMultiMatchQuery(phrase, tmp.Fields...).Type(typ)
+  } else {
+    tmp.Q = elastic.NewMatchPhraseQuery(tmp.Field, phrase)
+  }
+  vs.Push(tmp)
+}
+
+// "*" and "?" patterns are term-level, so they render as "wildcard" clauses in both query and filter context
+func (vs *ValueStack) Wildcard(pattern string) {
+  tmp := vs.current()
+  vs.target(tmp)
+  tmp.Q = vs.perField(tmp, false, func(field string) elastic.Query {
+    return elastic.NewWildcardQuery(field, pattern)
+  })
+  vs.Push(tmp)
+}
+
+// a NEAR/n or BEFORE/n proximity chain, rendered as span_near queries on a single field
+func (vs *ValueStack) Proximity(expr string) {
+  tmp := vs.current()
+  vs.target(tmp)
+  if len(tmp.Fields) > 0 {
+    vs.abort(fmt.Errorf("proximity clause %q must target a single field, got %q", expr, tmp.Field))
+  }
+  vs.rejectMetaFields(tmp, "proximity clauses")
+
+  span, err := ParseProximity(tmp.Field, expr)
+  if err != nil {
+    vs.abort(err)
+  }
+  tmp.Q = elastic.NewRawStringQuery(span)
+  vs.Push(tmp)
+}
+
+// a LIKE(fields){"texts"} or LIKE(_id:doc) clause, rendered as a more_like_this query
+func (vs *ValueStack) MoreLikeThis(expr string) {
+  mlt, err := ParseMoreLikeThis(expr)
+  if err != nil {
+    vs.abort(err)
+  }
+
+  tmp := vs.current()
+  tmp.Q = mlt
+  vs.Push(tmp)
+}
+
+// "*" or "ALL" literal
+func (vs *ValueStack) MatchAll() {
+  tmp := vs.current()
+  tmp.Q = elastic.NewMatchAllQuery()
+  vs.Push(tmp)
+}
+
+// "NONE" literal
+func (vs *ValueStack) MatchNone() {
+  tmp := vs.current()
+  tmp.Q = elastic.NewMatchNoneQuery()
+  vs.Push(tmp)
+}
+
+// embeds a RAW{...} JSON query as-is, for query types the DSL doesn't support
+func (vs *ValueStack) Raw(raw string) {
+  if vs.DisableRaw {
+    vs.abort(fmt.Errorf("RAW{...} clauses are disabled, rejecting %s", raw))
+  }
+
+  var parsed map[string]interface{}
+  if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+    vs.abort(fmt.Errorf("RAW clause must be a valid JSON object, got %s, err=%s", raw, err))
+  }
+
+  tmp := vs.current()
+  tmp.Q = elastic.NewRawStringQuery(raw)
+  vs.Push(tmp)
+}
+
+// passes QS"..." through to a query_string query. only \" is unescaped, other backslashes are Lucene's
+func (vs *ValueStack) QueryString(lucene string) {
+  if vs.DisableQueryString {
+    vs.abort(fmt.Errorf("QS\"...\" clauses are disabled, rejecting %q", lucene))
+  }
+
+  tmp := vs.current()
+  vs.target(tmp)
+
+  qsq := elastic.NewQueryStringQuery(strings.Replace(lucene, `\"`, `"`, -1))
+  if len(tmp.Fields) > 0 {
+    for _, field := range tmp.Fields {
+      qsq.Field(field)
+    }
+  } else {
+    qsq.DefaultField(tmp.Field)
+  }
+  tmp.Q = qsq
+  vs.Push(tmp)
+}
+
+// applies trailing "@and" or "{key=value, ...}" modifiers to the text value just parsed
+func (vs *ValueStack) Modify(mods string) {
+  tmp := vs.Pop()
+  if tmp == nil || tmp.Q == NoQuery {
+    vs.abort(fmt.Errorf("modifiers %s must follow a value", mods))
+  }
+
+  opts, err := ParseModifiers(mods)
+  if err != nil {
+    vs.abort(err)
+  }
+
+  // phrase queries have no operator, msm or fuzziness, so a phrase given one is searched as plain words
+  if tmp.Phrase != "" {
+    for _, m := range opts {
+      if matchOnlyModifiers[m.Key] {
+        tmp.Q = vs.matchQuery(tmp, tmp.Phrase)
+        break
+      }
+    }
+  }
+
+  for _, m := range opts {
+    switch q := tmp.Q.(type) {
+    case *elastic.MatchQuery:
+      err = modifyMatch(q, m)
+    case *elastic.MatchPhraseQuery:
+      err = modifyPhrase(q, m)
+    case *elastic.MultiMatchQuery:
+      err = modifyMultiMatch(q, m)
+    case *elastic.MoreLikeThisQuery:
+      err = modifyMoreLikeThis(q, m)
+    default:
+      err = fmt.Errorf("modifiers %s only apply to text values in query context and LIKE clauses, not to field %q here", mods, tmp.Field)
+    }
+    if err != nil {
+      vs.abort(err)
+    }
   }
 
-  tmp.Q = elastic.NewMatchPhraseQuery(tmp.Field, phrase)
   vs.Push(tmp)
 }
 
 // TODO: this is hacky, separate out the number and date range handling
 func (vs *ValueStack) Window(fromTildaTo string) {
   tmp := vs.current()
+  vs.target(tmp)
 
   fromTo := strings.Split(fromTildaTo, "~")
   var from interface{} = fromTo[0]
@@ -215,53 +684,291 @@ func (vs *ValueStack) Window(fromTildaTo string) {
   var err error
   if _, err = time.Parse(time.RFC3339, fromTo[0]); err != nil {
     if from, err = strconv.ParseFloat(fromTo[0], 10); err != nil {
-      log.Fatalf("[ERROR] failed to parse range window, from args must be valid RFC3339 datetime or number, got %q, err=%s", fromTildaTo, err)
+      vs.abort(fmt.Errorf("failed to parse range window, from args must be valid RFC3339 datetime or number, got %q, err=%s", fromTildaTo, err))
     }
   }
 
   if _, err = time.Parse(time.RFC3339, fromTo[1]); err != nil {
     if to, err = strconv.ParseFloat(fromTo[1], 10); err != nil {
-      log.Fatalf("[ERROR] failed to parse range window, to args must be valid RFC3339 datetime or number, got %q, err=%s", fromTildaTo, err)
+      vs.abort(fmt.Errorf("failed to parse range window, to args must be valid RFC3339 datetime or number, got %q, err=%s", fromTildaTo, err))
     }
   }
 
-  tmp.Q = elastic.NewRangeQuery(tmp.Field).From(from).To(to).IncludeLower(true).IncludeUpper(false)
+  tmp.Q = vs.perField(tmp, false, func(field string) elastic.Query {
+    return elastic.NewRangeQuery(field).From(from).To(to).IncludeLower(true).IncludeUpper(false)
+  })
+  vs.Push(tmp)
+}
+
+// compares the value's field against another field of the same doc, i.e. "closed_at:>$due_at"
+func (vs *ValueStack) CompareField(other string) {
+  tmp := vs.current()
+  vs.target(tmp)
+
+  other = Unescape(other)
+  if strings.Contains(other, "*") {
+    vs.abort(fmt.Errorf("can't compare field %q against wildcard field $%s", tmp.Field, other))
+  }
+  if tmp.RangeOp.Symbol() == "" {
+    vs.abort(fmt.Errorf("invalid range operation (code %d) comparing field %q against $%s", tmp.RangeOp, tmp.Field, other))
+  }
+  if tmp.BoundOp != NoOp {
+    vs.abort(fmt.Errorf("chained comparisons can't end in a field reference, got $%s for field %q", other, tmp.Field))
+  }
+
+  src := fmt.Sprintf(fieldCompareScript, tmp.RangeOp.Symbol())
+  tmp.Q = vs.perField(tmp, false, func(field string) elastic.Query {
+    params := map[string]interface{}{"left": field, "right": other}
+    return elastic.NewScriptQuery(elastic.NewScript(src).Lang("painless").Params(params))
+  })
   vs.Push(tmp)
 }
 
-func (vs *ValueStack) NumberRangeOrMatchTerm(filtered bool, value string) {
+func (vs *ValueStack) NumberRangeOrMatchTerm(value string) {
   num, err := strconv.ParseFloat(value, 10)
   if err != nil {
-    log.Fatalf("[ERROR] failed to parse numerical value from %q, err=%s", value, err)
+    vs.abort(fmt.Errorf("failed to parse numerical value from %q, err=%s", value, err))
   }
 
   // if this isn't an in-progress KV parse of a range, its a number, just pass the value along
   switch vs.Empty() || vs.stack[len(vs.stack) - 1].RangeOp == NoOp {
   case true:
-    vs.Number(filtered, num)
+    vs.Number(vs.inFilter(), num)
   case false:
     vs.Range(num)
   }
 }
 
-func (vs *ValueStack) DateRangeOrMatchTerm(filtered bool, value string) {
+func (vs *ValueStack) DateRangeOrMatchTerm(value string) {
   t, err := time.Parse(time.RFC3339, value)
   if err != nil {
-    log.Fatalf("[ERROR] failed to parse RFC3339 datetime in UTC from %q, err=%s", value, err)
+    vs.abort(fmt.Errorf("failed to parse RFC3339 datetime in UTC from %q, err=%s", value, err))
   }
 
   // if this isn't an in-progress KV parse of a range, its a plain value, just pass it along
   switch vs.Empty() || vs.stack[len(vs.stack) - 1].RangeOp == NoOp {
   case true:
-    vs.Date(filtered, t)
+    vs.Date(vs.inFilter(), t)
   case false:
     vs.Range(t)
   }
 }
 
+// a "${name}" placeholder, replaced by its bound value. the value is type-checked against the
+// placeholder's position, and is used as-is rather than parsed as DSL
+func (vs *ValueStack) Placeholder(name string) {
+  ranged := !vs.Empty() && vs.stack[len(vs.stack) - 1].RangeOp != NoOp
+  if vs.Template {
+    vs.slot(name, false)
+    if ranged {
+      vs.Range(ValueSlot(name))
+    } else {
+      vs.MatchTerm(ValueSlot(name))
+    }
+    return
+  }
+
+  value, found := vs.param(name)
+  if !found {
+    vs.MatchNone()
+    return
+  }
+  if ranged {
+    bound, err := paramBound(name, value)
+    if err != nil {
+      vs.fail(err)
+      vs.MatchNone()
+      return
+    }
+    vs.Range(bound)
+    return
+  }
+
+  if kind := reflect.ValueOf(value).Kind(); kind == reflect.Slice || kind == reflect.Array {
+    vs.fail(fmt.Errorf("placeholder ${%s} is bound to a list, which can only follow IN: \"field:IN ${%s}\"", name, name))
+    vs.MatchNone()
+    return
+  }
+  scalar, err := paramScalar(name, value)
+  if err != nil {
+    vs.fail(err)
+    vs.MatchNone()
+    return
+  }
+  switch v := scalar.(type) {
+  case string:
+    vs.MatchTerm(v)
+  case float64:
+    vs.Number(vs.inFilter(), v)
+  case time.Time:
+    vs.Date(vs.inFilter(), v)
+  default:
+    vs.Term(v)
+  }
+}
+
+// an "IN ${name}" placeholder, bound to a list of values
+func (vs *ValueStack) ListPlaceholder(name string) {
+  if vs.Template {
+    // a _type list renders one "type" clause per value, which a single slot can't stand in for
+    tmp := vs.current()
+    vs.target(tmp)
+    if tmp.Field == "_type" {
+      vs.abort(fmt.Errorf("meta-field _type can't take a list placeholder in template mode, got ${%s}", name))
+    }
+    vs.Push(tmp)
+
+    vs.slot(name, true)
+    vs.Term([]interface{}{ListSlot(name)})
+    return
+  }
+
+  value, found := vs.param(name)
+  if !found {
+    vs.MatchNone()
+    return
+  }
+  list, err := paramList(name, value)
+  if err != nil {
+    vs.fail(err)
+    vs.MatchNone()
+    return
+  }
+  vs.Term(list)
+}
+
+// records a template mode placeholder, which can't be both a list and a scalar
+func (vs *ValueStack) slot(name string, list bool) {
+  if vs.slots == nil {
+    vs.slots = map[string]bool{}
+  }
+  if seen, found := vs.slots[name]; found && seen != list {
+    vs.fail(fmt.Errorf("placeholder ${%s} is used both as a list after IN and as a single value", name))
+  }
+  vs.slots[name] = list
+}
+
+// the placeholders seen in template mode, by name: true for lists
+func (vs *ValueStack) Slots() map[string]bool {
+  return vs.slots
+}
+
+func (vs *ValueStack) param(name string) (interface{}, bool) {
+  value, found := vs.Params[name]
+  if !found {
+    vs.fail(fmt.Errorf("no value is bound to placeholder ${%s}", name))
+  }
+  return value, found
+}
+
+// reports a bind, type or registry error: collected in Errors when Collect is set, fatal otherwise.
+// callers push a "NONE" value in place of the failed one, so parsing can carry on
+func (vs *ValueStack) fail(err error) {
+  if !vs.Collect {
+    log.Fatalf("[ERROR] %s", err)
+  }
+  vs.Errors = append(vs.Errors, err)
+}
+
+// reports an error parsing can't carry on from, like mixed operators or a malformed value
+func (vs *ValueStack) abort(err error) {
+  abort(vs.Collect, err)
+}
+
+// a query error raised as a panic by stacks with Collect set, for grammar.Translate to recover
+type Abort struct {
+  Err           error
+}
+
+func abort(collect bool, err error) {
+  if !collect {
+    log.Fatalf("[ERROR] %s", err)
+  }
+  panic(Abort{err})
+}
+
+// typed literals skip the shape-based guessing: str and ip values are always exact terms,
+// num and date values render like other numbers and datetimes. all can be range bounds
+func (vs *ValueStack) Typed(literal string) {
+  kind, value, err := ParseTypedLiteral(literal)
+  if err != nil {
+    vs.fail(err)
+    vs.MatchNone()
+    return
+  }
+
+  if !vs.Empty() && vs.stack[len(vs.stack) - 1].RangeOp != NoOp {
+    vs.Range(value)
+    return
+  }
+  switch kind {
+  case "num":
+    vs.Number(vs.inFilter(), value)
+  case "date":
+    vs.Date(vs.inFilter(), value)
+  default:
+    vs.Term(value)
+  }
+}
+
+// a registered function call like "geohash(u4pruy)", applied to the value's field(s)
+func (vs *ValueStack) Call(call string) {
+  name, args, err := parseCall(call)
+  if err != nil {
+    vs.fail(err)
+    vs.MatchNone()
+    return
+  }
+  fn, found := functions[name]
+  if !found {
+    vs.fail(fmt.Errorf("unknown function %q in %s, registered functions are: %s", name, call, strings.Join(sortedKeys(functions), ", ")))
+    vs.MatchNone()
+    return
+  }
+
+  tmp := vs.current()
+  vs.target(tmp)
+  tmp.Q = vs.perField(tmp, false, func(field string) elastic.Query {
+    q, err := fn(field, args)
+    if err != nil {
+      vs.fail(fmt.Errorf("function %s on field %q: %s", call, field, err))
+      return elastic.NewMatchNoneQuery()
+    }
+    return q
+  })
+  vs.Push(tmp)
+}
+
+// a registered infix operator and its right-hand value, like "~= jon", applied to the key's field(s)
+func (vs *ValueStack) CustomOperator(opValue string) {
+  op := opValue[:len(opValue) - len(strings.TrimLeft(opValue, operatorChars))]
+  value := strings.TrimSpace(opValue[len(op):])
+  if strings.HasPrefix(value, `"`) {
+    value = Unescape(value[1:len(value) - 1])
+  }
+  fn, found := operators[op]
+  if !found {
+    vs.fail(fmt.Errorf("unknown operator %q in %q, registered operators are: %s", op, opValue, strings.Join(sortedKeys(operators), ", ")))
+    vs.MatchNone()
+    return
+  }
+
+  tmp := vs.current()
+  vs.target(tmp)
+  tmp.Q = vs.perField(tmp, false, func(field string) elastic.Query {
+    q, err := fn(field, value)
+    if err != nil {
+      vs.fail(fmt.Errorf("operator %s on field %q: %s", op, field, err))
+      return elastic.NewMatchNoneQuery()
+    }
+    return q
+  })
+  vs.Push(tmp)
+}
+
 // values should land in a "match" clause in query context, "term" clause in filter context
-func (vs *ValueStack) MatchTerm(filtered bool, value string) {
-  switch filtered {
+func (vs *ValueStack) MatchTerm(value string) {
+  switch vs.inFilter() {
   case true:
     vs.Term(value)
   case false:
@@ -271,9 +978,29 @@ func (vs *ValueStack) MatchTerm(filtered bool, value string) {
 
 func (vs *ValueStack) Range(value interface{}) {
   tmp := vs.current()
-  rq := elastic.NewRangeQuery(tmp.Field)
+  vs.target(tmp)
+  if tmp.RangeOp < LessThan || tmp.RangeOp > GreaterThanEqual {
+    vs.abort(fmt.Errorf("invalid range operation (code %d) parsing range value %q for field %q", tmp.RangeOp, value, tmp.Field))
+  }
+
+  if tmp.BoundOp != NoOp && tmp.BoundOp.IsLower() == tmp.RangeOp.IsLower() {
+    vs.abort(fmt.Errorf("chained comparison on field %q must bound it from both sides, got %s %v and %s %v", tmp.Field, tmp.BoundOp.Symbol(), tmp.Bound, tmp.RangeOp.Symbol(), value))
+  }
 
-  switch tmp.RangeOp {
+  tmp.Q = vs.perField(tmp, false, func(field string) elastic.Query {
+    rq := elastic.NewRangeQuery(field)
+    setBound(rq, tmp.RangeOp, value)
+    if tmp.BoundOp != NoOp {
+      setBound(rq, tmp.BoundOp, tmp.Bound)
+    }
+    return rq
+  })
+
+  vs.Push(tmp)
+}
+
+func setBound(rq *elastic.RangeQuery, op RangeOp, value interface{}) {
+  switch op {
   case LessThan:
     rq.Lt(value)
   case LessThanEqual:
@@ -282,11 +1009,6 @@ func (vs *ValueStack) Range(value interface{}) {
     rq.Gt(value)
   case GreaterThanEqual:
     rq.Gte(value)
-  default:
-    log.Fatalf("[ERROR] invalid range operation (code %d) parsing range value %q for field %q", tmp.RangeOp, value, tmp.Field)
   }
-  tmp.Q = rq
-
-  vs.Push(tmp)
 }
 